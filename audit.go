@@ -0,0 +1,243 @@
+package cperfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cperfc/config"
+	"cperfc/log"
+)
+
+const (
+	AuditCgroupWrite = "cgroup_write"
+	AuditAPI = "api"
+)
+
+const auditController = "controller"
+
+type AuditEntry struct {
+	Seq				int64			`json:"seq"`
+	Timestamp		time.Time		`json:"timestamp"`
+	Action			string			`json:"action"`				// {cgroup_write, api}
+	Actor			string			`json:"actor"`				// API identity or controller
+	Role			string			`json:"role,omitempty"`
+	Method			string			`json:"method"`				// {token, certificate, unix, anonymous, none, controller}
+	Container		string			`json:"container,omitempty"`
+	Write			*CgroupWrite	`json:"write,omitempty"`
+	Request			string			`json:"request,omitempty"`		// method and URL of the API request
+	Status			int				`json:"status,omitempty"`
+	Policy			string			`json:"policy,omitempty"`
+	Reason			string			`json:"reason,omitempty"`
+	Usage			float64			`json:"usage,omitempty"`		// observed usage when the controller decided
+	Error			string			`json:"error,omitempty"`
+}
+
+type AuditFilter struct {
+	Container		string
+	Actor			string
+	Action			string
+	Since			time.Time
+	Limit			int
+}
+
+type AuditList struct {
+	Entries			[]AuditEntry	`json:"entries"`
+	Total			int				`json:"total"`			// matched entries in memory
+}
+
+// AuditLog appends entries to a JSON lines file and keeps the recent ones for queries.
+type AuditLog struct {
+	lock			sync.Mutex
+	file			*log.RotatingFile
+	fileName		string
+	recent			[]AuditEntry
+	next			int
+	count			int
+	seq				int64
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status			int
+}
+
+var auditLog AuditLog
+
+func init() {
+}
+
+func GetAuditLog() *AuditLog {
+	return &auditLog
+}
+
+// StartAudit opens the audit file of config, or keeps the current one if it is unchanged.
+func StartAudit() error {
+	self := GetAuditLog()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if config.AuditRecent != len(self.recent) {
+		self.recent = make([]AuditEntry, config.AuditRecent)
+		self.next = 0
+		self.count = 0
+	}
+	if (self.file != nil) && (self.fileName == config.AuditFile) {
+		self.file.SetRotation(config.AuditMaxSize, config.AuditMaxAge, config.AuditMaxBackups)
+		return nil
+	}
+	if self.file != nil {
+		self.file.Close()
+		self.file = nil
+	}
+	self.fileName = config.AuditFile
+	if len(config.AuditFile) == 0 {
+		return nil
+	}
+	file, err := log.OpenRotatingFile(config.AuditFile, config.AuditMaxSize, config.AuditMaxAge, config.AuditMaxBackups)
+	if err != nil {
+		self.fileName = ""
+		return fmt.Errorf("audit.file: %s", err)
+	}
+	self.file = file
+	log.Infof("Audit log is written to %s.", config.AuditFile)
+	return nil
+}
+
+func (self *AuditLog)Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	self.fileName = ""
+	return err
+}
+
+func (self *AuditLog)Record(entry AuditEntry) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.seq++
+	entry.Seq = self.seq
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = clock()
+	}
+	if len(self.recent) > 0 {
+		self.recent[self.next] = entry
+		self.next = (self.next + 1) % len(self.recent)
+		if self.count < len(self.recent) {
+			self.count++
+		}
+	}
+	if self.file == nil {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err == nil {
+		_, err = self.file.Write(append(b, '\n'))
+	}
+	if err != nil {
+		log.Errorf("Failed to write the audit log: %s", err)
+	}
+}
+
+// Query returns the latest matched entries in order, at most filter.Limit if it is positive.
+func (self *AuditLog)Query(filter AuditFilter) AuditList {
+	list := AuditList{Entries: []AuditEntry{}}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for i := 0; i < self.count; i++ {
+		entry := self.recent[(self.next - self.count + i + len(self.recent)) % len(self.recent)]
+		if ((len(filter.Container) > 0) && (entry.Container != filter.Container)) ||
+			((len(filter.Actor) > 0) && (entry.Actor != filter.Actor)) ||
+			((len(filter.Action) > 0) && (entry.Action != filter.Action)) ||
+			entry.Timestamp.Before(filter.Since) {
+			continue
+		}
+		list.Entries = append(list.Entries, entry)
+	}
+	list.Total = len(list.Entries)
+	if (filter.Limit > 0) && (len(list.Entries) > filter.Limit) {
+		list.Entries = list.Entries[len(list.Entries) - filter.Limit:]
+	}
+	return list
+}
+
+// auditAPIWrite records a cgroup write requested over the API.
+func auditAPIWrite(r *http.Request, container string, write CgroupWrite, err error) {
+	identity := GetAPIIdentity(r)
+	entry := AuditEntry{Action: AuditCgroupWrite, Actor: identity.Name, Role: identity.Role, Method: identity.Method, Container: container, Write: &write, Request: r.Method + " " + r.URL.RequestURI()}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	GetAuditLog().Record(entry)
+}
+
+// auditAPIRequest records a request which needs the operator role or more, allowed or not.
+func auditAPIRequest(r *http.Request, identity *APIIdentity, container string, status int) {
+	entry := AuditEntry{Action: AuditAPI, Actor: "unknown", Method: "none", Container: container, Request: r.Method + " " + r.URL.RequestURI(), Status: status}
+	if identity != nil {
+		entry.Actor = identity.Name
+		entry.Role = identity.Role
+		entry.Method = identity.Method
+	}
+	if status >= 400 {
+		entry.Error = http.StatusText(status)
+	}
+	GetAuditLog().Record(entry)
+}
+
+func subscribeAudit() {
+	GetEventBus().SubscribeSync("audit", []string{EventCgroupWritten}, func(event Event) {
+		entry := AuditEntry{Timestamp: event.Timestamp, Action: AuditCgroupWrite, Actor: auditController, Method: auditController, Container: event.Id, Write: event.Write, Error: event.Error}
+		if event.Action != nil {
+			entry.Policy = event.Action.Policy
+			entry.Reason = event.Action.Reason
+			entry.Usage = event.Action.Usage
+		}
+		GetAuditLog().Record(entry)
+	})
+}
+
+func (self *auditResponseWriter)WriteHeader(status int) {
+	if self.status == 0 {
+		self.status = status
+	}
+	self.ResponseWriter.WriteHeader(status)
+}
+
+func (self *auditResponseWriter)Write(b []byte) (int, error) {
+	if self.status == 0 {
+		self.status = http.StatusOK
+	}
+	return self.ResponseWriter.Write(b)
+}
+
+func (self *auditResponseWriter)Flush() {
+	if flusher, ok := self.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func restfulV1Audit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AuditFilter{Container: query.Get("cid"), Actor: query.Get("actor"), Action: query.Get("action"), Limit: 100}
+	since, err := parseTimeParam(query.Get("since"))
+	if err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong 'since': %s", query.Get("since")))
+		return
+	}
+	filter.Since = since
+	if limit := query.Get("limit"); len(limit) > 0 {
+		if filter.Limit, err = strconv.Atoi(limit); (err != nil) || (filter.Limit < 0) {
+			writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong 'limit': %s", limit))
+			return
+		}
+	}
+	writeV1(w, r, http.StatusOK, GetAuditLog().Query(filter))
+}
@@ -0,0 +1,227 @@
+package cperfc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"cperfc/config"
+	"cperfc/log"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin = "admin"
+	RolePublic = "public"		// no authentication, only for routes like probes
+)
+
+const (
+	ErrorUnauthorized = "unauthorized"
+	ErrorForbidden = "forbidden"
+)
+
+type AuthToken struct {
+	Name			string			`json:"name"`
+	Token			string			`json:"token"`
+	Role			string			`json:"role"`
+}
+
+type AuthCertificate struct {
+	Subject			string			`json:"subject"`		// common name of the client certificate
+	Role			string			`json:"role"`
+}
+
+type AuthConfig struct {
+	Tokens			[]AuthToken			`json:"tokens,omitempty"`
+	Certificates	[]AuthCertificate	`json:"certificates,omitempty"`
+	Anonymous		string				`json:"anonymous,omitempty"`	// role of unauthenticated requests, rejected if empty
+}
+
+type APIIdentity struct {
+	Name			string			`json:"name"`
+	Role			string			`json:"role"`
+	Method			string			`json:"method"`		// {token, certificate, unix, anonymous, none}
+}
+
+type authContextKey struct {
+}
+
+var authRoleLevels = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+var authConfig *AuthConfig
+var authLock sync.RWMutex
+
+func init() {
+}
+
+func StartAuth() error {
+	if len(config.AuthFile) == 0 {
+		SetAuthConfig(nil)
+		log.Warn("API authentication is disabled, everyone is an admin")
+		return nil
+	}
+	auth, err := LoadAuthConfig(config.AuthFile)
+	if err != nil {
+		return err
+	}
+	SetAuthConfig(auth)
+	log.Infof("API authentication: %d tokens, %d certificates are loaded from %s.", len(auth.Tokens), len(auth.Certificates), config.AuthFile)
+	return nil
+}
+
+func LoadAuthConfig(name string) (*AuthConfig, error) {
+	var auth AuthConfig
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&auth); err != nil {
+		return nil, err
+	}
+	if err := auth.Validate(); err != nil {
+		return nil, err
+	}
+	if (len(config.SocketRole) > 0) && !IsRole(config.SocketRole) {
+		return nil, fmt.Errorf("socket-role: unknown role '%s'", config.SocketRole)
+	}
+	return &auth, nil
+}
+
+func (self *AuthConfig)Validate() error {
+	for i, token := range self.Tokens {
+		if len(token.Token) == 0 {
+			return fmt.Errorf("tokens[%d] '%s': empty token", i, token.Name)
+		}
+		if !IsRole(token.Role) {
+			return fmt.Errorf("tokens[%d] '%s': unknown role '%s'", i, token.Name, token.Role)
+		}
+	}
+	for i, certificate := range self.Certificates {
+		if len(certificate.Subject) == 0 {
+			return fmt.Errorf("certificates[%d]: empty subject", i)
+		}
+		if !IsRole(certificate.Role) {
+			return fmt.Errorf("certificates[%d] '%s': unknown role '%s'", i, certificate.Subject, certificate.Role)
+		}
+	}
+	if (len(self.Anonymous) > 0) && !IsRole(self.Anonymous) {
+		return fmt.Errorf("anonymous: unknown role '%s'", self.Anonymous)
+	}
+	return nil
+}
+
+func SetAuthConfig(auth *AuthConfig) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	authConfig = auth
+}
+
+func IsRole(role string) bool {
+	_, exist := authRoleLevels[role]
+	return exist
+}
+
+func HasRole(role string, required string) bool {
+	return authRoleLevels[role] >= authRoleLevels[required]
+}
+
+func authenticate(r *http.Request) (*APIIdentity, bool) {
+	authLock.RLock()
+	defer authLock.RUnlock()
+	if authConfig == nil {
+		return &APIIdentity{Name: "anonymous", Role: RoleAdmin, Method: "none"}, true
+	}
+	if header := r.Header.Get("Authorization"); len(header) > 0 {
+		if !strings.HasPrefix(header, "Bearer ") {
+			return nil, false
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		for _, candidate := range authConfig.Tokens {
+			if subtle.ConstantTimeCompare([]byte(candidate.Token), []byte(token)) == 1 {
+				return &APIIdentity{Name: candidate.Name, Role: candidate.Role, Method: "token"}, true
+			}
+		}
+		return nil, false
+	}
+	if (r.TLS != nil) && (len(r.TLS.VerifiedChains) > 0) {
+		subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, candidate := range authConfig.Certificates {
+			if candidate.Subject == subject {
+				return &APIIdentity{Name: subject, Role: candidate.Role, Method: "certificate"}, true
+			}
+		}
+		return nil, false
+	}
+	config.RLock()
+	socketRole := config.SocketRole
+	config.RUnlock()
+	if unix, _ := r.Context().Value(unixSocketContextKey{}).(bool); unix && (len(socketRole) > 0) {
+		return &APIIdentity{Name: "unix", Role: socketRole, Method: "unix"}, true
+	}
+	if len(authConfig.Anonymous) > 0 {
+		return &APIIdentity{Name: "anonymous", Role: authConfig.Anonymous, Method: "anonymous"}, true
+	}
+	return nil, false
+}
+
+func GetAPIIdentity(r *http.Request) *APIIdentity {
+	if identity, ok := r.Context().Value(authContextKey{}).(*APIIdentity); ok {
+		return identity
+	}
+	return &APIIdentity{Name: "anonymous", Method: "none"}
+}
+
+func authorizer(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+
+		required := RoleAdmin
+		if router.Match(r, &match) && (match.Route != nil) {
+			template, _ := match.Route.GetPathTemplate()
+			if role, exist := findOpenAPIRole(r.Method, template); exist {
+				required = role
+			}
+		} else {
+			required = RoleViewer
+		}
+		if required == RolePublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity, ok := authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer realm=\"cperfc\"")
+			writeV1Error(w, r, newAPIError(http.StatusUnauthorized, ErrorUnauthorized, "Authentication is required"))
+			if HasRole(required, RoleOperator) {
+				auditAPIRequest(r, nil, match.Vars["cid"], http.StatusUnauthorized)
+			}
+			return
+		}
+		if !HasRole(identity.Role, required) {
+			writeV1Error(w, r, newAPIError(http.StatusForbidden, ErrorForbidden, "'%s'(%s) is not allowed, '%s' role is required", identity.Name, identity.Role, required))
+			if HasRole(required, RoleOperator) {
+				auditAPIRequest(r, identity, match.Vars["cid"], http.StatusForbidden)
+			}
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, identity))
+		if !HasRole(required, RoleOperator) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		auditAPIRequest(r, identity, match.Vars["cid"], recorder.status)
+	})
+}
@@ -0,0 +1,277 @@
+package cperfc
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	cAdvisorClient "github.com/google/cadvisor/client"
+	cAdvisorInfo "github.com/google/cadvisor/info/v1"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+	"cperfc/log"
+)
+
+const (
+	CircuitClosed = "closed"
+	CircuitOpen = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+type CAdvisorStatus struct {
+	Address			string			`json:"address"`
+	State			string			`json:"state"`				// {closed, open, half_open}
+	Failures		int				`json:"failures"`			// consecutive
+	Backoff			float64			`json:"backoff"`			// second
+	RetryAt			time.Time		`json:"retry_at,omitempty"`
+	Requests		int64			`json:"requests"`
+	Errors			int64			`json:"errors"`
+	LastError		string			`json:"last_error,omitempty"`
+	LastErrorAt		time.Time		`json:"last_error_at,omitempty"`
+}
+
+// CAdvisorBreaker is a circuit breaker around the cAdvisor client. It opens after
+// 'cadvisor_client.failures' consecutive failures of cAdvisor and lets a probe through
+// after the backoff, which doubles up to 'cadvisor_client.max_backoff' while it fails.
+type CAdvisorBreaker struct {
+	lock			sync.Mutex
+	client			*cAdvisorClient.Client
+	timeout			time.Duration
+	status			CAdvisorStatus
+	backoff			time.Duration
+}
+
+var ErrCircuitOpen = errors.New("cAdvisor circuit is open")
+
+var cAdvisorBreaker = CAdvisorBreaker{status: CAdvisorStatus{State: CircuitClosed}}
+var startupStop chan bool
+var startupGroup sync.WaitGroup
+
+func init() {
+}
+
+func GetCAdvisor() *CAdvisorBreaker {
+	return &cAdvisorBreaker
+}
+
+func (self *CAdvisorBreaker)getClient() (*cAdvisorClient.Client, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	timeout := time.Duration(config.CAdvisorTimeout) * time.Second
+	if (self.client != nil) && (self.status.Address == config.CAdvisorAddr) && (self.timeout == timeout) {
+		return self.client, nil
+	}
+	client, err := cAdvisorClient.NewClientWithTimeout(config.CAdvisorAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	self.client = client
+	self.timeout = timeout
+	self.status.Address = config.CAdvisorAddr
+	return client, nil
+}
+
+// allow is false while the circuit is open, and turns it to half open after the backoff.
+func (self *CAdvisorBreaker)allow() bool {
+	self.lock.Lock()
+	if (self.status.State != CircuitOpen) || clock().Before(self.status.RetryAt) {
+		allowed := self.status.State != CircuitOpen
+		self.lock.Unlock()
+		return allowed
+	}
+	self.status.State = CircuitHalfOpen
+	self.lock.Unlock()
+	GetEventBus().Publish(Event{Type: EventCAdvisorState, Reason: CircuitHalfOpen, Message: fmt.Sprintf("cAdvisor[%s] is probed", config.CAdvisorAddr)})
+	return true
+}
+
+func (self *CAdvisorBreaker)MachineInfo() (*cAdvisorInfo.MachineInfo, error) {
+	if !self.allow() {
+		return nil, ErrCircuitOpen
+	}
+	return self.Probe()
+}
+
+// Probe calls cAdvisor even if the circuit is open and records the result.
+func (self *CAdvisorBreaker)Probe() (*cAdvisorInfo.MachineInfo, error) {
+	client, err := self.getClient()
+	if err != nil {
+		self.Record(err)
+		return nil, err
+	}
+	machine, err := client.MachineInfo()
+	if (err == nil) && (machine == nil) {
+		err = errors.New("no machine info")
+	}
+	self.Record(err)
+	return machine, err
+}
+
+// ContainerInfo does not change the circuit, an error of a container is not of cAdvisor.
+func (self *CAdvisorBreaker)ContainerInfo(name string, request *cAdvisorInfo.ContainerInfoRequest) (*cAdvisorInfo.ContainerInfo, error) {
+	self.lock.Lock()
+	open := self.status.State == CircuitOpen
+	self.lock.Unlock()
+	if open {
+		return nil, ErrCircuitOpen
+	}
+	client, err := self.getClient()
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.ContainerInfo(name, request)
+	if (err == nil) && (info == nil) {
+		err = fmt.Errorf("no container info of %s", name)
+	}
+	self.lock.Lock()
+	self.status.Requests++
+	if err != nil {
+		self.status.Errors++
+	}
+	self.lock.Unlock()
+	return info, err
+}
+
+func (self *CAdvisorBreaker)SubcontainersInfo(name string, request *cAdvisorInfo.ContainerInfoRequest) ([]cAdvisorInfo.ContainerInfo, error) {
+	if !self.allow() {
+		return nil, ErrCircuitOpen
+	}
+	client, err := self.getClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.SubcontainersInfo(name, request)
+}
+
+// Record counts a result of cAdvisor itself and opens or closes the circuit.
+func (self *CAdvisorBreaker)Record(err error) {
+	var event *Event
+
+	self.lock.Lock()
+	now := clock()
+	self.status.Requests++
+	if err == nil {
+		if self.status.State != CircuitClosed {
+			event = &Event{Type: EventCAdvisorState, Reason: CircuitClosed, Message: fmt.Sprintf("cAdvisor[%s] is back after %d failures", config.CAdvisorAddr, self.status.Failures)}
+		}
+		self.status.State = CircuitClosed
+		self.status.Failures = 0
+		self.status.RetryAt = time.Time{}
+		self.backoff = 0
+	} else {
+		self.status.Errors++
+		self.status.Failures++
+		self.status.LastError = err.Error()
+		self.status.LastErrorAt = now
+		if (self.status.State == CircuitHalfOpen) || ((self.status.State == CircuitClosed) && (self.status.Failures >= config.CAdvisorFailures)) {
+			self.backoff = nextBackoff(self.backoff)
+			self.status.State = CircuitOpen
+			self.status.RetryAt = now.Add(self.backoff)
+			event = &Event{Type: EventCAdvisorState, Reason: CircuitOpen, Error: err.Error(), Message: fmt.Sprintf("cAdvisor[%s] failed %d times, retry in %s", config.CAdvisorAddr, self.status.Failures, self.backoff)}
+		}
+	}
+	self.status.Backoff = self.backoff.Seconds()
+	self.lock.Unlock()
+	recordCAdvisor(err, err == nil)
+	if err != nil {
+		GetEventBus().Publish(Event{Type: EventCAdvisorError, Error: err.Error(), Message: fmt.Sprintf("cAdvisor[%s]", config.CAdvisorAddr)})
+	}
+	if event != nil {
+		GetEventBus().Publish(*event)
+	}
+}
+
+func (self *CAdvisorBreaker)Status() CAdvisorStatus {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	status := self.status
+	if len(status.Address) == 0 {
+		status.Address = config.CAdvisorAddr
+	}
+	return status
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	maxBackoff := time.Duration(config.CAdvisorMaxBackoff) * time.Second
+	if backoff == 0 {
+		backoff = time.Duration(config.CAdvisorBackoff) * time.Second
+	} else {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// waitCAdvisor starts the main loop once cAdvisor answers unless it is paused, or fails the daemon after
+// 'cadvisor_client.startup_timeout'.
+func waitCAdvisor(stop chan bool) {
+	defer startupGroup.Done()
+	started := time.Now()
+	backoff := time.Duration(0)
+	for {
+		config.RLock()
+		_, err := GetCAdvisor().Probe()
+		address, timeout := config.CAdvisorAddr, config.CAdvisorStartupTimeout
+		if err != nil {
+			backoff = nextBackoff(backoff)
+		}
+		config.RUnlock()
+		if err == nil {
+			log.Infof("cAdvisor[%s] is running.", address)
+			if resumeMainLoop() {
+				log.Info("Starting monitoring.")
+			} else {
+				log.Info("Monitoring is paused, it starts on '/control/resume'.")
+			}
+			return
+		}
+		if (timeout > 0) && (time.Since(started) >= time.Duration(timeout) * time.Second) {
+			failDaemon(newDaemonError(config.EXITCADVISOR, "Failed to connect cAdvisor[%s] for %d seconds: %s", address, timeout, err))
+			return
+		}
+		log.Warnf("Failed to connect cAdvisor[%s], retry in %s: %s", address, backoff, err)
+		select {
+		case <- time.After(backoff):
+		case <- stop:
+			return
+		}
+	}
+}
+
+// sampleFailed isolates a container failing to sample by retrying it later, and removes it if it is gone.
+func sampleFailed(container *Container, err error, now time.Time) {
+	if !cgroups.IsContainerExist(container.Id) {
+		GetContainerManager().RemoveDisappearedContainer(container.Id)
+		return
+	}
+	container.lock.Lock()
+	container.sampleFailures++
+	failures := container.sampleFailures
+	retry := sampleRetry(failures)
+	container.sampleRetryAt = now.Add(retry)
+	container.lock.Unlock()
+	recordCAdvisor(err, true)
+	GetEventBus().Publish(Event{Type: EventCAdvisorError, Id: container.Id, Container: container, Error: err.Error(), Message: fmt.Sprintf("failed %d times, retry in %s", failures, retry)})
+}
+
+// sampleRetry is the interval doubled by each failure up to 32 times, capped by 'cadvisor_client.max_backoff'.
+func sampleRetry(failures int) time.Duration {
+	skip := 32
+	if failures <= 5 {
+		skip = 1 << uint(failures - 1)
+	}
+	retry := time.Duration(skip * config.MainLoopInterval) * time.Second
+	if maxBackoff := time.Duration(config.CAdvisorMaxBackoff) * time.Second; retry > maxBackoff {
+		retry = maxBackoff
+	}
+	return retry
+}
+
+func containerInfoName(container *Container) string {
+	return path.Join("/", path.Join(container.Type, container.Id))
+}
@@ -0,0 +1,118 @@
+package cperfc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cAdvisorInfo "github.com/google/cadvisor/info/v1"
+
+	"cperfc/config"
+)
+
+func TestSampleRetry(t *testing.T) {
+	defer func(interval int, maxBackoff int) {
+		config.MainLoopInterval, config.CAdvisorMaxBackoff = interval, maxBackoff
+	}(config.MainLoopInterval, config.CAdvisorMaxBackoff)
+	config.MainLoopInterval = 1
+	config.CAdvisorMaxBackoff = 3600
+	tests := []struct {
+		failures		int
+		expected		time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{5, 16 * time.Second},
+		{6, 32 * time.Second},
+		{64, 32 * time.Second},
+		{65, 32 * time.Second},
+		{1000, 32 * time.Second},
+	}
+	for _, test := range tests {
+		if retry := sampleRetry(test.failures); retry != test.expected {
+			t.Errorf("%d failures: expected %s, got %s", test.failures, test.expected, retry)
+		}
+	}
+	config.CAdvisorMaxBackoff = 10
+	if retry := sampleRetry(100); retry != 10 * time.Second {
+		t.Errorf("expected max_backoff 10s, got %s", retry)
+	}
+}
+
+func TestCAdvisorBreakerTransitions(t *testing.T) {
+	defer func(failures int, backoff int, maxBackoff int, now func() time.Time) {
+		config.CAdvisorFailures, config.CAdvisorBackoff, config.CAdvisorMaxBackoff, clock = failures, backoff, maxBackoff, now
+	}(config.CAdvisorFailures, config.CAdvisorBackoff, config.CAdvisorMaxBackoff, clock)
+	config.CAdvisorFailures = 2
+	config.CAdvisorBackoff = 1
+	config.CAdvisorMaxBackoff = 2
+	now := at(0)
+	clock = func() time.Time { return now }
+	breaker := &CAdvisorBreaker{status: CAdvisorStatus{State: CircuitClosed}}
+	expect := func(step string, state string, allowed bool, backoff time.Duration) {
+		t.Helper()
+		if got := breaker.allow(); got != allowed {
+			t.Errorf("%s: expected allowed %v, got %v", step, allowed, got)
+		}
+		status := breaker.Status()
+		if (status.State != state) || (breaker.backoff != backoff) {
+			t.Errorf("%s: expected %s with backoff %s, got %s with %s", step, state, backoff, status.State, breaker.backoff)
+		}
+	}
+	failure := errors.New("connection refused")
+
+	breaker.Record(failure)
+	expect("a failure", CircuitClosed, true, 0)
+	breaker.Record(failure)
+	expect("failures", CircuitOpen, false, time.Second)
+	now = now.Add(time.Second)
+	expect("after the backoff", CircuitHalfOpen, true, time.Second)
+	breaker.Record(failure)
+	expect("a failed probe", CircuitOpen, false, 2 * time.Second)
+	now = now.Add(2 * time.Second)
+	expect("after the doubled backoff", CircuitHalfOpen, true, 2 * time.Second)
+	breaker.Record(failure)
+	expect("a failed probe over max_backoff", CircuitOpen, false, 2 * time.Second)
+	now = now.Add(2 * time.Second)
+	expect("after max_backoff", CircuitHalfOpen, true, 2 * time.Second)
+	breaker.Record(nil)
+	expect("a successful probe", CircuitClosed, true, 0)
+	breaker.Record(failure)
+	expect("a failure after closed", CircuitClosed, true, 0)
+}
+
+func TestPauseKeptOverStartup(t *testing.T) {
+	cAdvisor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(cAdvisorInfo.MachineInfo{NumCores: 4})
+	}))
+	defer cAdvisor.Close()
+	defer func(address string) { config.CAdvisorAddr = address }(config.CAdvisorAddr)
+	config.CAdvisorAddr = cAdvisor.URL
+	defer func() {
+		loopLock.Lock()
+		stopMainLoop()
+		loopPaused = false
+		loopLock.Unlock()
+		loopGroup.Wait()
+	}()
+
+	StopMainLoop()
+	startupGroup.Add(1)
+	waitCAdvisor(make(chan bool))
+	if GetLoopStatus().Running {
+		t.Fatal("a pause before cAdvisor is reachable is undone")
+	}
+	StartMainLoop()
+	if !GetLoopStatus().Running {
+		t.Fatal("expected the main loop to be resumed")
+	}
+	startupGroup.Add(1)
+	waitCAdvisor(make(chan bool))
+	StopMainLoop()
+	if GetLoopStatus().Running {
+		t.Error("expected a single main loop to be paused")
+	}
+}
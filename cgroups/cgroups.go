@@ -12,12 +12,19 @@ import (
 	"cperfc/log"
 )
 
+type CgroupContainer struct {
+	Id			string
+	Type		string
+	Path		string
+}
+
 type SubSystemManager struct {
 	SubSystem	[]string
 	Path		map[string]string
 }
 
 const cgroupPath = "/sys/fs/cgroup"
+const defaultShares = "1024"
 var subSystemManager SubSystemManager
 
 func init() {
@@ -39,9 +46,11 @@ func (self *SubSystemManager)init() {
 	entries, _ := ioutil.ReadDir(cgroupPath)
 	for _, file := range entries {
 		if file.IsDir() {
-			if (file.Name() == config.CpuSetSubSystem) {
-				self.SubSystem =  append(self.SubSystem, file.Name())
-				self.Path[file.Name()] = path.Join(cgroupPath, file.Name())
+			for _, name := range strings.Split(file.Name(), ",") {
+				if (name == config.CpuSetSubSystem) || (name == config.CpuSubSystem) {
+					self.SubSystem =  append(self.SubSystem, name)
+					self.Path[name] = path.Join(cgroupPath, file.Name())
+				}
 			}
 		}
 	}
@@ -93,39 +102,74 @@ func GetCoreInfoOfLxcContainer(containerId string) (string, error) {
 	return GetCoreInfoOfContainer(config.LxcName, containerId, "cpuset.cpus")
 }
 
+// isContainerCgroup is true if name is the cgroup of the container cid, '<cid>' or systemd's 'docker-<cid>.scope'.
+func isContainerCgroup(name string, cid string) bool {
+	return (name == cid) || (name == config.DockerName + "-" + cid + ".scope")
+}
+
 func GetParentContainer(subSystem string, cid string) []string {
 	var parents []string
+
+	for _, fullPath := range GetContainerFullPath(subSystem, cid) {
+		parents = append(parents, path.Dir(fullPath))
+	}
+	return parents
+}
+
+func GetAllContainers(subSystem string) []CgroupContainer {
+	var containers []CgroupContainer
 	var walk func(string)
 
 	subdSystemPath, exist := GetSubSystemManager().GetSubSystemPath(subSystem)
 	if !exist {
-		return parents
+		return containers
 	}
 	walk = func(parentPath string) {
+		_, parent := path.Split(parentPath)
 		children, _ := ioutil.ReadDir(parentPath)
 		for _, child := range children {
 			if !child.IsDir() {
 				continue
 			}
-			if child.Name() == cid {
-				parents = append(parents, parentPath)
-			} else {
-				walk(path.Join(parentPath, child.Name()))
+			name := child.Name()
+			switch {
+			case (parent == config.DockerName) || (parent == config.LxcName):
+				containers = append(containers, CgroupContainer{Id: name, Type: parent, Path: path.Join(parentPath, name)})
+			case strings.HasPrefix(name, config.DockerName + "-") && strings.HasSuffix(name, ".scope"):
+				id := strings.TrimSuffix(strings.TrimPrefix(name, config.DockerName + "-"), ".scope")
+				containers = append(containers, CgroupContainer{Id: id, Type: config.DockerName, Path: path.Join(parentPath, name)})
+			default:
+				walk(path.Join(parentPath, name))
 			}
 		}
 	}
 	walk(subdSystemPath)
-	return parents
+	return containers
 }
 
 func GetContainerFullPath(subSystem string, cid string) []string {
 	var fullPath []string
+	var walk func(string)
 
-	parents := GetParentContainer(subSystem, cid)
-	for _, parent := range parents {
-		fullPath = append(fullPath, path.Join(parent, cid))
+	subdSystemPath, exist := GetSubSystemManager().GetSubSystemPath(subSystem)
+	if !exist || (len(cid) == 0) {
+		return fullPath
 	}
-	return parents
+	walk = func(parentPath string) {
+		children, _ := ioutil.ReadDir(parentPath)
+		for _, child := range children {
+			if !child.IsDir() {
+				continue
+			}
+			if isContainerCgroup(child.Name(), cid) {
+				fullPath = append(fullPath, path.Join(parentPath, child.Name()))
+			} else {
+				walk(path.Join(parentPath, child.Name()))
+			}
+		}
+	}
+	walk(subdSystemPath)
+	return fullPath
 }
 
 func IsContainerExist(cid string) bool {
@@ -134,24 +178,78 @@ func IsContainerExist(cid string) bool {
 }
 
 func GetContainerType(cid string) string {
-	fullPath := GetParentContainer(config.CpuSetSubSystem, cid)
-	if len(fullPath) > 0 {
-		_, file := path.Split(fullPath[0])
-		return file
+	fullPath := GetContainerFullPath(config.CpuSetSubSystem, cid)
+	if len(fullPath) == 0 {
+		return ""
+	}
+	if path.Base(fullPath[0]) != cid {
+		return config.DockerName
+	}
+	return path.Base(path.Dir(fullPath[0]))
+}
+
+// ReadCgroupValue reads which of the cgroup at fullPath.
+func ReadCgroupValue(fullPath string, which string) (string, error) {
+	b, err := ioutil.ReadFile(path.Join(fullPath, which))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func GetCgroupValue(subSystem string, cid string, which string) (string, error) {
+	fullPath := GetContainerFullPath(subSystem, cid)
+	if len(fullPath) == 0 {
+		return "", fmt.Errorf("container '%s' is not in '%s' subsystem", cid, subSystem)
 	}
-	return ""
+	return ReadCgroupValue(fullPath[0], which)
+}
+
+func SetCgroupValue(subSystem string, cid string, which string, value string) error {
+	fullPath := GetContainerFullPath(subSystem, cid)
+	if len(fullPath) == 0 {
+		return fmt.Errorf("container '%s' is not in '%s' subsystem", cid, subSystem)
+	}
+	log.Debugf("%s <- %s", path.Join(fullPath[0], which), value)
+	return ioutil.WriteFile(path.Join(fullPath[0], which), []byte(value), 0644)
+}
+
+func SetCPUSet(cid string, cpus string) error {
+	return SetCgroupValue(config.CpuSetSubSystem, cid, "cpuset.cpus", cpus)
+}
+
+func SetCPUShares(cid string, shares string) error {
+	return SetCgroupValue(config.CpuSubSystem, cid, "cpu.shares", shares)
 }
 
 func ResetCgroupInfo(cid string) {
 	for _, subSystem := range GetSubSystemManager().GetAllSubSystems() {
-		resetCgroupInfo(subSystem, GetContainerFullPath(subSystem, cid)[0])
+		if err := ResetCgroupSubSystem(subSystem, cid); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+func ResetCgroupSubSystem(subSystem string, cid string) error {
+	fullPath := GetContainerFullPath(subSystem, cid)
+	if len(fullPath) == 0 {
+		return fmt.Errorf("container '%s' is not in '%s' subsystem", cid, subSystem)
 	}
+	return resetCgroupInfo(subSystem, fullPath[0])
 }
 
-func resetCgroupInfo(subSystem string, fullPath string) {
+func resetCgroupInfo(subSystem string, fullPath string) error {
 	switch subSystem {
 	case config.CpuSetSubSystem:
+		b, err := ioutil.ReadFile(path.Join(path.Dir(fullPath), "cpuset.cpus"))
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path.Join(fullPath, "cpuset.cpus"), b, 0644)
+	case config.CpuSubSystem:
+		return ioutil.WriteFile(path.Join(fullPath, "cpu.shares"), []byte(defaultShares), 0644)
 	}
+	return nil
 }
 
 func DecodeListFormat(expression string) []int {
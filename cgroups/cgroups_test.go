@@ -0,0 +1,81 @@
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"cperfc/config"
+)
+
+func makeCgroupTree(t *testing.T, dirs ...string) string {
+	root := t.TempDir()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(root, dir, "cpuset.cpus"), []byte(dir + "\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := subSystemManager
+	t.Cleanup(func() { subSystemManager = saved })
+	subSystemManager = SubSystemManager{SubSystem: []string{config.CpuSetSubSystem}, Path: map[string]string{config.CpuSetSubSystem: root}}
+	return root
+}
+
+func TestContainerLookup(t *testing.T) {
+	root := makeCgroupTree(t, "docker/abc", "lxc/web", "system.slice/docker-def.scope", "system.slice/cron.service")
+	tests := []struct {
+		cid				string
+		fullPath		string			// relative to root, empty if not exist
+		containerType	string
+	}{
+		{"abc", "docker/abc", config.DockerName},
+		{"web", "lxc/web", config.LxcName},
+		{"def", "system.slice/docker-def.scope", config.DockerName},
+		{"cron.service", "system.slice/cron.service", "system.slice"},
+		{"none", "", ""},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		fullPath := GetContainerFullPath(config.CpuSetSubSystem, test.cid)
+		if len(test.fullPath) == 0 {
+			if len(fullPath) > 0 || IsContainerExist(test.cid) {
+				t.Errorf("%s: expected not to exist, got %v", test.cid, fullPath)
+			}
+			continue
+		}
+		if (len(fullPath) != 1) || (fullPath[0] != path.Join(root, test.fullPath)) {
+			t.Errorf("%s: expected %s, got %v", test.cid, test.fullPath, fullPath)
+			continue
+		}
+		if containerType := GetContainerType(test.cid); containerType != test.containerType {
+			t.Errorf("%s: expected type %s, got %s", test.cid, test.containerType, containerType)
+		}
+		if cpus, err := GetCgroupValue(config.CpuSetSubSystem, test.cid, "cpuset.cpus"); (err != nil) || (cpus != test.fullPath) {
+			t.Errorf("%s: expected cpuset.cpus %s, got %s(%v)", test.cid, test.fullPath, cpus, err)
+		}
+	}
+}
+
+func TestGetAllContainers(t *testing.T) {
+	root := makeCgroupTree(t, "docker/abc", "system.slice/docker-def.scope", "system.slice/cron.service")
+	containers := GetAllContainers(config.CpuSetSubSystem)
+	expected := map[string]CgroupContainer{
+		"abc": {Id: "abc", Type: config.DockerName, Path: path.Join(root, "docker/abc")},
+		"def": {Id: "def", Type: config.DockerName, Path: path.Join(root, "system.slice/docker-def.scope")},
+	}
+	if len(containers) != len(expected) {
+		t.Fatalf("expected %d containers, got %v", len(expected), containers)
+	}
+	for _, container := range containers {
+		if container != expected[container.Id] {
+			t.Errorf("expected %v, got %v", expected[container.Id], container)
+		}
+		if cpus, err := ReadCgroupValue(container.Path, "cpuset.cpus"); (err != nil) || (len(cpus) == 0) {
+			t.Errorf("%s: failed to read cpuset.cpus: %v", container.Id, err)
+		}
+	}
+}
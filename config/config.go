@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"os"
+	"strings"
 )
 
 const (
@@ -11,28 +12,170 @@ const (
 	EXITPORT = 80
 	EXITCADVISOR = 2
 	EXITLOG = 3
+	EXITAUTH = 4
+	EXITCONFIG = 5
+	EXITSHUTDOWN = 6
 )
-const LOOPSKIPCOUNT = 5
 
 const DockerName = "docker"
 const LxcName = "lxc"
 const CpuSetSubSystem = "cpuset"
+const CpuSubSystem = "cpu"
 
 var LogFormat = "text"
 var LogLevel = "info"
+var LogModules []string
+var LogFile = ""
+var LogMaxSize = 100
+var LogMaxAge = 0
+var LogMaxBackups = 5
+var LogSyslog = ""
 var CAdvisorAddr = "http://localhost:8080"
 var ListeningPort = 8088
 var MainLoopInterval = 10
+var HistoryLength = 360
+var DefaultPolicy = "threshold"
+var ScalingCooldown = 30
+var DryRun = false
+var TraceFile = ""
+var OpenAPICheck = false
+var WebhookFile = ""
+var WebhookRetries = 5
+var WebhookTimeout = 5
+var AuthFile = ""
+var TLSCertFile = ""
+var TLSKeyFile = ""
+var TLSClientCAFile = ""
+var Listeners []string
+var SocketMode = "0660"
+var SocketOwner = ""
+var SocketGroup = ""
+var SocketRole = ""
+
+var ConfigFile = ""
+var PrintConfig = false
+var StateDir = "."
+var DockerPolicy = ""
+var LxcPolicy = ""
+var ReservedCores = ""
+var ShutdownTimeout = 30
+var CAdvisorFailures = 3
+var CAdvisorBackoff = 1
+var CAdvisorMaxBackoff = 60
+var CAdvisorStartupTimeout = 0
+var CAdvisorWorkers = 8
+var CAdvisorTimeout = 5
+var Pprof = false
+var AuditFile = ""
+var AuditMaxSize = 100
+var AuditMaxAge = 0
+var AuditMaxBackups = 10
+var AuditRecent = 1000
+var RestoreCgroups = false
+
+type listFlag struct {
+	list			*[]string
+}
+
+type setting struct {
+	Key				string			// key in the configuration file, '.' for nesting
+	Flag			string
+	Value			interface{}		// pointer to the global
+	Usage			string
+}
+
+var settings = []setting{
+	{"listeners", "listen", &Listeners, "API listener = {[http://]host:port, https://host:port, unix:///path}, repeatable, ':<port>' if none"},
+	{"port", "port", &ListeningPort, "port for RESTful API serving"},
+	{"socket.mode", "socket-mode", &SocketMode, "file mode of unix socket listeners"},
+	{"socket.owner", "socket-owner", &SocketOwner, "owner of unix socket listeners"},
+	{"socket.group", "socket-group", &SocketGroup, "group of unix socket listeners"},
+	{"socket.role", "socket-role", &SocketRole, "role of unauthenticated requests over unix sockets = {viewer, operator, admin}"},
+	{"tls.cert", "tls-cert", &TLSCertFile, "certificate file to serve the API over HTTPS"},
+	{"tls.key", "tls-key", &TLSKeyFile, "private key file to serve the API over HTTPS"},
+	{"tls.client_ca", "tls-client-ca", &TLSClientCAFile, "CA file to verify client certificates"},
+	{"auth_file", "auth", &AuthFile, "JSON file of API tokens, client certificates and their roles, no authentication if empty"},
+	{"openapi_check", "openapi-check", &OpenAPICheck, "validate every API response against the OpenAPI document"},
+	{"cadvisor", "cadvisor", &CAdvisorAddr, "address to cAdvisor API server"},
+	{"cadvisor_client.failures", "cadvisor-failures", &CAdvisorFailures, "consecutive cAdvisor failures to open the circuit and stop asking it"},
+	{"cadvisor_client.backoff", "cadvisor-backoff", &CAdvisorBackoff, "seconds to retry cAdvisor first, doubled while it fails"},
+	{"cadvisor_client.max_backoff", "cadvisor-max-backoff", &CAdvisorMaxBackoff, "maximum seconds to retry cAdvisor or a failing container"},
+	{"cadvisor_client.startup_timeout", "cadvisor-startup-timeout", &CAdvisorStartupTimeout, "seconds to wait for cAdvisor at startup before exiting, 0 to wait forever"},
+	{"cadvisor_client.workers", "cadvisor-workers", &CAdvisorWorkers, "number of containers sampled from cAdvisor in parallel"},
+	{"cadvisor_client.timeout", "cadvisor-timeout", &CAdvisorTimeout, "timeout for a cAdvisor request in second"},
+	{"interval", "interval", &MainLoopInterval, "interval for monitoring in second"},
+	{"history", "history", &HistoryLength, "number of samples kept per container"},
+	{"cooldown", "cooldown", &ScalingCooldown, "minimum seconds between two scaling actions of a container"},
+	{"state_dir", "state-dir", &StateDir, "directory to keep the registered containers"},
+	{"log.format", "logformat", &LogFormat, "log format = {text, json}"},
+	{"log.level", "loglevel", &LogLevel, "log level = {info, warning, fatal, error, panic, debug}"},
+	{"log.modules", "log-module", &LogModules, "log level of a module as '<module>=<level>', e.g. 'cgroups=debug', repeatable"},
+	{"log.file", "log-file", &LogFile, "file to write logs instead of stderr"},
+	{"log.max_size", "log-max-size", &LogMaxSize, "size in MB to rotate the log file, 0 not to rotate by size"},
+	{"log.max_age", "log-max-age", &LogMaxAge, "age in hours to rotate the log file, 0 not to rotate by age"},
+	{"log.max_backups", "log-max-backups", &LogMaxBackups, "number of rotated log files kept, 0 to keep all"},
+	{"log.syslog", "log-syslog", &LogSyslog, "also send logs to syslog = {local, udp://host:port, tcp://host:port}, journald reads 'local'"},
+	{"policy.default", "policy", &DefaultPolicy, "default scaling policy = {none, threshold, proportional, pid, predictive, slo}"},
+	{"policy.docker", "policy-docker", &DockerPolicy, "default scaling policy of docker containers, 'policy.default' if empty"},
+	{"policy.lxc", "policy-lxc", &LxcPolicy, "default scaling policy of lxc containers, 'policy.default' if empty"},
+	{"reserved_cores", "reserved-cores", &ReservedCores, "cores never given to containers by scaling, e.g. '0-1'"},
+	{"dry_run", "dryrun", &DryRun, "only recommend scaling actions without writing cgroups"},
+	{"trace", "trace", &TraceFile, "file to record monitoring samples, gzipped if it ends with '.gz'"},
+	{"webhooks.file", "webhooks", &WebhookFile, "JSON file of webhooks to notify scaling and lifecycle events"},
+	{"webhooks.retries", "webhook-retries", &WebhookRetries, "number of retries for a failed webhook delivery"},
+	{"webhooks.timeout", "webhook-timeout", &WebhookTimeout, "timeout for a webhook delivery in second"},
+	{"audit.file", "audit", &AuditFile, "JSON lines file of cgroup writes and API mutations, only in memory if empty"},
+	{"audit.max_size", "audit-max-size", &AuditMaxSize, "size in MB to rotate the audit file, 0 not to rotate by size"},
+	{"audit.max_age", "audit-max-age", &AuditMaxAge, "age in hours to rotate the audit file, 0 not to rotate by age"},
+	{"audit.max_backups", "audit-max-backups", &AuditMaxBackups, "number of rotated audit files kept, 0 to keep all"},
+	{"audit.recent", "audit-recent", &AuditRecent, "number of audit entries kept in memory for /v1/audit"},
+	{"debug.pprof", "pprof", &Pprof, "serve pprof under /debug/pprof/ for admins"},
+	{"shutdown.timeout", "shutdown-timeout", &ShutdownTimeout, "seconds to drain API requests on shutdown"},
+	{"shutdown.restore_cgroups", "restore-cgroups", &RestoreCgroups, "reset cgroups of the registered containers on shutdown"},
+}
 
 func init() {
 }
 
-func ParseCommandLine() {
+func GetDefaultPolicy(containerType string) string {
+	switch {
+	case (containerType == DockerName) && (len(DockerPolicy) > 0):
+		return DockerPolicy
+	case (containerType == LxcName) && (len(LxcPolicy) > 0):
+		return LxcPolicy
+	}
+	return DefaultPolicy
+}
+
+func (self listFlag)String() string {
+	if self.list == nil {
+		return ""
+	}
+	return strings.Join(*self.list, ",")
+}
+
+func (self listFlag)Set(value string) error {
+	*self.list = append(*self.list, value)
+	return nil
+}
+
+func ParseCommandLine() error {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	flag.StringVar(&LogFormat, "logformat", LogFormat, "log format = {text, json}")
-	flag.StringVar(&LogLevel, "loglevel", LogLevel, "log level = {info, warning, fatal, error, panic, debug}")
-	flag.IntVar(&MainLoopInterval, "interval", MainLoopInterval, "interval for monitoring in second")
-	flag.IntVar(&ListeningPort, "port", ListeningPort, "port for RESTful API serving")
-	flag.StringVar(&CAdvisorAddr, "cadvisor", CAdvisorAddr, "address to cAdvisor API server")
+	flag.StringVar(&ConfigFile, "config", ConfigFile, "YAML configuration file, overridden by $CPERFC_* and flags")
+	flag.BoolVar(&PrintConfig, "print-config", PrintConfig, "print the effective configuration and exit")
+	for _, entry := range settings {
+		switch value := entry.Value.(type) {
+		case *string:
+			flag.StringVar(value, entry.Flag, *value, entry.Usage)
+		case *int:
+			flag.IntVar(value, entry.Flag, *value, entry.Usage)
+		case *bool:
+			flag.BoolVar(value, entry.Flag, *value, entry.Usage)
+		case *[]string:
+			flag.Var(listFlag{value}, entry.Flag, entry.Usage)
+		}
+	}
+	defaultSettings = Snapshot()
 	flag.Parse()
+	return Load()
 }
@@ -0,0 +1,447 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+type ConfigError struct {
+	Errors			[]string
+}
+
+const envPrefix = "CPERFC_"
+
+var defaultSettings map[string]interface{}
+var overrides = make(map[string]interface{})
+var overridesLock sync.Mutex
+var settingsLock sync.RWMutex
+var cpuListFormat = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+func init() {
+}
+
+func (self *ConfigError)Error() string {
+	return "invalid configuration:\n\t" + strings.Join(self.Errors, "\n\t")
+}
+
+func (self *ConfigError)Add(format string, args ...interface{}) {
+	self.Errors = append(self.Errors, fmt.Sprintf(format, args...))
+}
+
+func (self *ConfigError)Append(err error) {
+	if err == nil {
+		return
+	}
+	if configError, ok := err.(*ConfigError); ok {
+		self.Errors = append(self.Errors, configError.Errors...)
+	} else {
+		self.Errors = append(self.Errors, err.Error())
+	}
+}
+
+func (self *ConfigError)Err() error {
+	if len(self.Errors) == 0 {
+		return nil
+	}
+	return self
+}
+
+func findSetting(key string) (*setting, bool) {
+	for i := range settings {
+		if settings[i].Key == key {
+			return &settings[i], true
+		}
+	}
+	return nil, false
+}
+
+// RLock keeps the settings from being swapped by a reload, e.g. during an iteration of the main loop or a request.
+func RLock() {
+	settingsLock.RLock()
+}
+
+func RUnlock() {
+	settingsLock.RUnlock()
+}
+
+func Snapshot() map[string]interface{} {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return snapshot()
+}
+
+func snapshot() map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	for _, entry := range settings {
+		switch value := entry.Value.(type) {
+		case *string:
+			snapshot[entry.Key] = *value
+		case *int:
+			snapshot[entry.Key] = *value
+		case *bool:
+			snapshot[entry.Key] = *value
+		case *[]string:
+			snapshot[entry.Key] = append([]string(nil), (*value)...)
+		}
+	}
+	return snapshot
+}
+
+func Restore(snapshot map[string]interface{}) {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	restore(snapshot)
+}
+
+func restore(snapshot map[string]interface{}) {
+	for _, entry := range settings {
+		saved, exist := snapshot[entry.Key]
+		if !exist {
+			continue
+		}
+		switch value := entry.Value.(type) {
+		case *string:
+			*value = saved.(string)
+		case *int:
+			*value = saved.(int)
+		case *bool:
+			*value = saved.(bool)
+		case *[]string:
+			*value = append([]string(nil), saved.([]string)...)
+		}
+	}
+}
+
+// Override sets a setting at runtime, e.g. '/control/dryrun', which is kept over the file, $CPERFC_* and flags on reloads.
+func Override(key string, value interface{}) {
+	overridesLock.Lock()
+	defer overridesLock.Unlock()
+	overrides[key] = value
+	Restore(map[string]interface{}{key: value})
+}
+
+func GetOverrides() map[string]interface{} {
+	overridesLock.Lock()
+	defer overridesLock.Unlock()
+	copied := make(map[string]interface{})
+	for key, value := range overrides {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Load rebuilds the settings and applies them, see Build.
+func Load() error {
+	built, err := Build()
+	Apply(built, nil)
+	return err
+}
+
+// Build merges defaults, the configuration file, $CPERFC_* and flags in order, then the runtime overrides,
+// without changing the current settings.
+func Build() (map[string]interface{}, error) {
+	current := Snapshot()
+	if defaultSettings == nil {
+		defaultSettings = current
+	}
+	built := make(map[string]interface{})
+	for key, value := range defaultSettings {
+		built[key] = value
+	}
+	errs := &ConfigError{}
+	if len(ConfigFile) > 0 {
+		errs.Append(loadFile(ConfigFile, built))
+	}
+	errs.Append(loadEnv(built))
+	flag.Visit(func(f *flag.Flag) {
+		for _, entry := range settings {
+			if entry.Flag == f.Name {
+				built[entry.Key] = current[entry.Key]
+			}
+		}
+	})
+	for key, value := range GetOverrides() {
+		built[key] = value
+	}
+	return built, errs.Err()
+}
+
+// Apply swaps the settings at once, and keeps the current ones if 'validate' fails with the new ones.
+func Apply(built map[string]interface{}, validate func() error) error {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	previous := snapshot()
+	restore(built)
+	if validate != nil {
+		if err := validate(); err != nil {
+			restore(previous)
+			return err
+		}
+	}
+	return nil
+}
+
+func loadFile(name string, built map[string]interface{}) error {
+	var document map[interface{}]interface{}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	errs := &ConfigError{}
+	loadMap("", document, built, errs)
+	if len(errs.Errors) > 0 {
+		for i := range errs.Errors {
+			errs.Errors[i] = name + ": " + errs.Errors[i]
+		}
+		return errs
+	}
+	return nil
+}
+
+func loadMap(prefix string, document map[interface{}]interface{}, built map[string]interface{}, errs *ConfigError) {
+	var keys []string
+
+	for key := range document {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := document[key]
+		fullKey := prefix + key
+		if entry, exist := findSetting(fullKey); exist {
+			if converted, err := convertValue(entry, value); err != nil {
+				errs.Add("%s: %s", fullKey, err)
+			} else {
+				built[fullKey] = converted
+			}
+		} else if child, ok := value.(map[interface{}]interface{}); ok {
+			loadMap(fullKey + ".", child, built, errs)
+		} else {
+			errs.Add("%s: unknown key", fullKey)
+		}
+	}
+}
+
+func convertValue(entry *setting, value interface{}) (interface{}, error) {
+	switch entry.Value.(type) {
+	case *string:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("expected a string, got %T(%v), quote it", value, value)
+	case *int:
+		if v, ok := value.(int); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("expected an integer, got %T(%v)", value, value)
+	case *bool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+		return nil, fmt.Errorf("expected true or false, got %T(%v)", value, value)
+	case *[]string:
+		switch v := value.(type) {
+		case string:
+			return []string{v}, nil
+		case []interface{}:
+			list := []string{}
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a list of strings, got %T(%v) in the list", item, item)
+				}
+				list = append(list, s)
+			}
+			return list, nil
+		}
+		return nil, fmt.Errorf("expected a list of strings, got %T(%v)", value, value)
+	}
+	return nil, fmt.Errorf("unsupported setting")
+}
+
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+func loadEnv(built map[string]interface{}) error {
+	errs := &ConfigError{}
+	for i := range settings {
+		entry := &settings[i]
+		name := EnvName(entry.Key)
+		text, exist := os.LookupEnv(name)
+		if !exist {
+			continue
+		}
+		var value interface{} = text
+		switch entry.Value.(type) {
+		case *int:
+			number, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil {
+				errs.Add("$%s: expected an integer, got '%s'", name, text)
+				continue
+			}
+			value = number
+		case *bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(text))
+			if err != nil {
+				errs.Add("$%s: expected true or false, got '%s'", name, text)
+				continue
+			}
+			value = b
+		case *[]string:
+			var list []interface{}
+			for _, item := range strings.Split(text, ",") {
+				if item = strings.TrimSpace(item); len(item) > 0 {
+					list = append(list, item)
+				}
+			}
+			value = list
+		}
+		if converted, err := convertValue(entry, value); err != nil {
+			errs.Add("$%s: %s", name, err)
+		} else {
+			built[entry.Key] = converted
+		}
+	}
+	return errs.Err()
+}
+
+func Validate() error {
+	errs := &ConfigError{}
+	if (ListeningPort < 1) || (ListeningPort > 65535) {
+		errs.Add("port: %d is out of range [1, 65535]", ListeningPort)
+	}
+	if MainLoopInterval < 1 {
+		errs.Add("interval: must be at least 1 second, got %d", MainLoopInterval)
+	}
+	if HistoryLength < 1 {
+		errs.Add("history: must be at least 1 sample, got %d", HistoryLength)
+	}
+	if ScalingCooldown < 0 {
+		errs.Add("cooldown: must not be negative, got %d", ScalingCooldown)
+	}
+	if (LogFormat != "text") && (LogFormat != "json") {
+		errs.Add("log.format: '%s' is not one of {text, json}", LogFormat)
+	}
+	if !isLogLevel(LogLevel) {
+		errs.Add("log.level: '%s' is not one of {info, warning, fatal, error, panic, debug}", LogLevel)
+	}
+	for _, module := range LogModules {
+		parts := strings.SplitN(module, "=", 2)
+		if (len(parts) != 2) || (len(strings.TrimSpace(parts[0])) == 0) {
+			errs.Add("log.modules: '%s' is not '<module>=<level>'", module)
+		} else if !isLogLevel(strings.TrimSpace(parts[1])) {
+			errs.Add("log.modules: '%s' has an unknown level", module)
+		}
+	}
+	if (LogMaxSize < 0) || (LogMaxAge < 0) || (LogMaxBackups < 0) {
+		errs.Add("log: 'max_size', 'max_age' and 'max_backups' must not be negative")
+	}
+	if (len(LogSyslog) > 0) && (LogSyslog != "local") && !strings.HasPrefix(LogSyslog, "udp://") && !strings.HasPrefix(LogSyslog, "tcp://") {
+		errs.Add("log.syslog: '%s' is not one of {local, udp://host:port, tcp://host:port}", LogSyslog)
+	}
+	if _, err := strconv.ParseUint(SocketMode, 8, 32); err != nil {
+		errs.Add("socket.mode: '%s' is not an octal file mode", SocketMode)
+	}
+	if (len(TLSCertFile) > 0) != (len(TLSKeyFile) > 0) {
+		errs.Add("tls: both 'cert' and 'key' are required")
+	}
+	if (len(ReservedCores) > 0) && !cpuListFormat.MatchString(ReservedCores) {
+		errs.Add("reserved_cores: '%s' is not a cpu list like '0-1,4'", ReservedCores)
+	}
+	if len(StateDir) == 0 {
+		errs.Add("state_dir: must not be empty")
+	} else if info, err := os.Stat(StateDir); (err != nil) || !info.IsDir() {
+		errs.Add("state_dir: '%s' is not a directory", StateDir)
+	}
+	if WebhookRetries < 0 {
+		errs.Add("webhooks.retries: must not be negative, got %d", WebhookRetries)
+	}
+	if WebhookTimeout < 1 {
+		errs.Add("webhooks.timeout: must be at least 1 second, got %d", WebhookTimeout)
+	}
+	if (AuditMaxSize < 0) || (AuditMaxAge < 0) || (AuditMaxBackups < 0) || (AuditRecent < 0) {
+		errs.Add("audit: 'max_size', 'max_age', 'max_backups' and 'recent' must not be negative")
+	}
+	if CAdvisorFailures < 1 {
+		errs.Add("cadvisor_client.failures: must be at least 1, got %d", CAdvisorFailures)
+	}
+	if (CAdvisorBackoff < 1) || (CAdvisorMaxBackoff < CAdvisorBackoff) {
+		errs.Add("cadvisor_client: 'backoff' must be at least 1 second and not larger than 'max_backoff'")
+	}
+	if CAdvisorStartupTimeout < 0 {
+		errs.Add("cadvisor_client.startup_timeout: must not be negative, got %d", CAdvisorStartupTimeout)
+	}
+	if CAdvisorWorkers < 1 {
+		errs.Add("cadvisor_client.workers: must be at least 1, got %d", CAdvisorWorkers)
+	}
+	if CAdvisorTimeout < 1 {
+		errs.Add("cadvisor_client.timeout: must be at least 1 second, got %d", CAdvisorTimeout)
+	}
+	if ShutdownTimeout < 1 {
+		errs.Add("shutdown.timeout: must be at least 1 second, got %d", ShutdownTimeout)
+	}
+	return errs.Err()
+}
+
+func isLogLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "panic", "fatal", "error", "warn", "warning", "info", "debug":
+		return true
+	}
+	return false
+}
+
+func Print(w io.Writer) error {
+	document := yaml.MapSlice{}
+	for _, entry := range settings {
+		var value interface{}
+		switch v := entry.Value.(type) {
+		case *string:
+			value = *v
+		case *int:
+			value = *v
+		case *bool:
+			value = *v
+		case *[]string:
+			value = *v
+			if *v == nil {
+				value = []string{}
+			}
+		}
+		document = setPath(document, strings.Split(entry.Key, "."), value)
+	}
+	b, err := yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func setPath(document yaml.MapSlice, path []string, value interface{}) yaml.MapSlice {
+	if len(path) == 1 {
+		return append(document, yaml.MapItem{Key: path[0], Value: value})
+	}
+	for i := range document {
+		if document[i].Key == path[0] {
+			document[i].Value = setPath(document[i].Value.(yaml.MapSlice), path[1:], value)
+			return document
+		}
+	}
+	return append(document, yaml.MapItem{Key: path[0], Value: setPath(yaml.MapSlice{}, path[1:], value)})
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestOverrideSurvivesLoad(t *testing.T) {
+	saved := Snapshot()
+	savedFile := ConfigFile
+	defer func() {
+		Restore(saved)
+		ConfigFile = savedFile
+		overrides = make(map[string]interface{})
+	}()
+	ConfigFile = path.Join(t.TempDir(), "cperfc.yaml")
+	if err := ioutil.WriteFile(ConfigFile, []byte("dry_run: false\ninterval: 5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Override("dry_run", true)
+	if !DryRun {
+		t.Fatal("expected the override to be applied")
+	}
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !DryRun {
+		t.Error("dry_run is turned off by a reload while it is overridden")
+	}
+	if MainLoopInterval != 5 {
+		t.Errorf("expected interval 5 from the file, got %d", MainLoopInterval)
+	}
+}
+
+func TestLoadKeepsSettingsDuringReload(t *testing.T) {
+	saved := Snapshot()
+	savedFile := ConfigFile
+	defer func() {
+		Restore(saved)
+		ConfigFile = savedFile
+	}()
+	ConfigFile = path.Join(t.TempDir(), "cperfc.yaml")
+	if err := ioutil.WriteFile(ConfigFile, []byte("interval: 7\nreserved_cores: \"0-1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := Load(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <- done:
+			return
+		default:
+		}
+		RLock()
+		interval, reserved := MainLoopInterval, ReservedCores
+		RUnlock()
+		if (interval != 7) || (reserved != "0-1") {
+			t.Fatalf("defaults are seen during a reload: interval=%d, reserved_cores=%s", interval, reserved)
+		}
+	}
+}
+
+func TestApplyKeepsSettingsOnValidationError(t *testing.T) {
+	saved := Snapshot()
+	defer Restore(saved)
+	built := Snapshot()
+	built["interval"] = 0
+	if err := Apply(built, Validate); err == nil {
+		t.Fatal("expected interval 0 to be rejected")
+	}
+	if MainLoopInterval != saved["interval"].(int) {
+		t.Errorf("expected interval %v to be kept, got %d", saved["interval"], MainLoopInterval)
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"path"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	cAdvisorInfo "github.com/google/cadvisor/info/v1"
 
+	"cperfc/config"
 	"cperfc/log"
 )
 
@@ -32,6 +36,9 @@ type CgroupCPUSet struct {
 type CgroupInfo struct {
 	CPUSet			CgroupCPUSet	`json:"cpuset"`
 	CPU				CgroupCPU		`json:"cpu"`
+	Policy			string			`json:"policy"`
+	SLO				CgroupSLO		`json:"slo"`
+	DryRun			bool			`json:"dry_run"`
 }
 
 type Container struct {
@@ -43,11 +50,54 @@ type Container struct {
 	CAdvisorInfo	cAdvisorInfo.ContainerInfo		`json:"cAdvisor"`
 	CPUUsageShort	float64				`json:"cpu_usage_short"`
 	CPUUsageLong	float64				`json:"cpu_usage_long"`
+	CoresUsedShort	float64				`json:"cores_used_short"`
+	CoresUsedLong	float64				`json:"cores_used_long"`
 	Timestamp		time.Time		`json:"Timestamp"`
+	History			*SampleHistory	`json:"-"`
+	Forecast		*ForecastReport	`json:"forecast,omitempty"`
+	SLOReport		*SLOReport		`json:"slo_report,omitempty"`
+	policy			ScalingPolicy
+	ceiling			string			// last ceiling notified, {max_cores, pool_exhausted}
+	sampleFailures	int				// consecutive failures to get the stats from cAdvisor
+	sampleRetryAt	time.Time
+	stats			[]*cAdvisorInfo.ContainerStats	// last stats from cAdvisor, fetched incrementally
+	masks			MaskTimeline	// cpuset changes to account the usage against
+	createdAt		time.Time		// to find a restart of the container
+	lock			sync.Mutex		// the fields changed by both the main loop and the API
+}
+
+type ContainerSummary struct {
+	Id				string			`json:"id"`
+	Type			string			`json:"type"`
+	Path			string			`json:"path"`
+	Image			string			`json:"image"`
+	Labels			map[string]string	`json:"labels"`
+	CgroupCurrent	CgroupInfo		`json:"cgroup_cur"`
+	CgroupRequest	CgroupInfo		`json:"cgroup_req"`
+	CPUUsageShort	float64			`json:"cpu_usage_short"`
+	CPUUsageLong	float64			`json:"cpu_usage_long"`
+	CoresUsedShort	float64			`json:"cores_used_short"`
+	CoresUsedLong	float64			`json:"cores_used_long"`
+	Timestamp		time.Time		`json:"Timestamp"`
+}
+
+type ContainerFilter struct {
+	Type			string
+	Labels			map[string]string
+	Image			string
+	MinUsage		float64
+	MaxUsage		float64			// ignored if not positive
+	SortBy			string			// {id, usage, cores}
+	Descending		bool
+	Offset			int
+	Limit			int				// no limit if not positive
 }
 
 type ContainerManager struct {
+	lock			sync.RWMutex
 	Containers		map[string]*Container
+	loaded			bool			// the registered containers are loaded from state_dir
+	loadMessage		string
 }
 
 var containerManager ContainerManager
@@ -61,6 +111,8 @@ func NewContainerManager() {
 	log.Println("Initializing registered containers.")
 	manager := GetContainerManager()
 	ok, msg := manager.load()
+	manager.loaded = ok
+	manager.loadMessage = msg
 	if ok {
 		outBuffer.WriteString(fmt.Sprintf("%d containers are under control.", len(manager.GetAllContainers())))
 		for _, container := range manager.GetAllContainers() {
@@ -78,12 +130,14 @@ func GetContainerManager() *ContainerManager {
 }
 
 func (self *ContainerManager)load() (ret bool, msg string) {
-	const metaName = "registered"
+	metaName := path.Join(config.StateDir, "registered")
 	Exists := func (name string) bool {
     	_, err := os.Stat(name)
     	return !os.IsNotExist(err)
 	}
 
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	self.Containers = make(map[string]*Container)
 	file, err := os.Open(metaName)
 	if err != nil {
@@ -95,11 +149,17 @@ func (self *ContainerManager)load() (ret bool, msg string) {
 	}
 	defer file.Close()
 	json.NewDecoder(file).Decode(&self.Containers)
+	for _, container := range self.Containers {
+		container.History = NewSampleHistory(config.HistoryLength)
+	}
 	return true, ""
 }
 
+// store must not be called with a container locked, since each container is locked to be encoded.
 func (self *ContainerManager)store() (ret bool, msg string) {
-	file, err := os.Create("registered")
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	file, err := os.Create(path.Join(config.StateDir, "registered"))
 	if err != nil {
 		return false, "Failed to save container metadata"
 	}
@@ -110,35 +170,147 @@ func (self *ContainerManager)store() (ret bool, msg string) {
 	return true, ""
 }
 
+// GetAllContainers returns a copy of the registered containers, which is safe to range over.
 func (self *ContainerManager)GetAllContainers() map[string]*Container {
-	return self.Containers
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	containers := make(map[string]*Container, len(self.Containers))
+	for id, container := range self.Containers {
+		containers[id] = container
+	}
+	return containers
+}
+
+func (self *ContainerManager)ListContainers(filter ContainerFilter) (summaries []ContainerSummary, total int) {
+	summaries = []ContainerSummary{}
+	for _, container := range self.GetAllContainers() {
+		summary := container.GetSummary()
+		if (len(filter.Type) > 0) && (summary.Type != filter.Type) {
+			continue
+		}
+		if (len(filter.Image) > 0) && !strings.Contains(summary.Image, filter.Image) {
+			continue
+		}
+		matched := true
+		for key, value := range filter.Labels {
+			if label, exist := summary.Labels[key]; !exist || ((len(value) > 0) && (label != value)) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if (summary.CPUUsageShort < filter.MinUsage) || ((filter.MaxUsage > 0) && (summary.CPUUsageShort > filter.MaxUsage)) {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if filter.Descending {
+			i, j = j, i
+		}
+		a, b := &summaries[i], &summaries[j]
+		switch filter.SortBy {
+		case "usage":
+			if a.CPUUsageShort != b.CPUUsageShort {
+				return a.CPUUsageShort < b.CPUUsageShort
+			}
+		case "cores":
+			if coresA, coresB := countCores(a.CgroupCurrent.CPUSet.CPUS), countCores(b.CgroupCurrent.CPUSet.CPUS); coresA != coresB {
+				return coresA < coresB
+			}
+		}
+		return a.Id < b.Id
+	})
+	total = len(summaries)
+	if filter.Offset > 0 {
+		if filter.Offset > len(summaries) {
+			filter.Offset = len(summaries)
+		}
+		summaries = summaries[filter.Offset:]
+	}
+	if (filter.Limit > 0) && (filter.Limit < len(summaries)) {
+		summaries = summaries[:filter.Limit]
+	}
+	return summaries, total
+}
+
+func (self *Container)GetSummary() ContainerSummary {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return ContainerSummary{
+		Id: self.Id,
+		Type: self.Type,
+		Path: self.Path,
+		Image: self.CAdvisorInfo.Spec.Image,
+		Labels: self.CAdvisorInfo.Spec.Labels,
+		CgroupCurrent: self.CgroupCurrent,
+		CgroupRequest: self.CgroupRequest,
+		CPUUsageShort: self.CPUUsageShort,
+		CPUUsageLong: self.CPUUsageLong,
+		CoresUsedShort: self.CoresUsedShort,
+		CoresUsedLong: self.CoresUsedLong,
+		Timestamp: self.Timestamp,
+	}
+}
+
+// MarshalJSON locks the container not to encode it while the main loop or the API changes it.
+func (self *Container)MarshalJSON() ([]byte, error) {
+	type container Container
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return json.Marshal((*container)(self))
 }
 
 func (self *ContainerManager)GetContainers(cid string) (*Container, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
 	container, exist := self.Containers[cid]
 	return container, exist
 }
 
+// AddContainer registers the container itself, the caller must not change it without its lock any more.
 func (self *ContainerManager)AddContainer(container *Container) bool {
-	if self.IsContainerRegistered(container.Id) {
+	self.lock.Lock()
+	if _, exist := self.Containers[container.Id]; exist {
+		self.lock.Unlock()
 		return false
 	}
-	copied := *container
-	self.Containers[container.Id] = &copied
+	container.History = NewSampleHistory(config.HistoryLength)
+	self.Containers[container.Id] = container
+	self.lock.Unlock()
 	self.store()
+	GetEventBus().Publish(Event{Type: EventContainerRegistered, Id: container.Id, Container: container})
 	return true
 }
 
 func (self *ContainerManager)RemoveContainer(id string) bool {
-	if !self.IsContainerRegistered(id) {
+	return self.removeContainer(id, "unregistered")
+}
+
+func (self *ContainerManager)RemoveDisappearedContainer(id string) bool {
+	return self.removeContainer(id, "disappeared")
+}
+
+func (self *ContainerManager)removeContainer(id string, reason string) bool {
+	self.lock.Lock()
+	container, exist := self.Containers[id]
+	if !exist {
+		self.lock.Unlock()
 		return false
 	}
 	delete(self.Containers, id)
+	self.lock.Unlock()
 	self.store()
+	GetEventBus().Publish(Event{Type: EventContainerRemoved, Id: id, Container: container, Reason: reason})
 	return true
 }
 
 func (self *ContainerManager)IsContainerRegistered(id string) bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
 	_, exist := self.Containers[id]
 	return exist
 }
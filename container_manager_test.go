@@ -0,0 +1,106 @@
+package cperfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"cperfc/config"
+)
+
+// TestContainerManagerConcurrency is meaningful with 'go test -race'.
+func TestContainerManagerConcurrency(t *testing.T) {
+	var group sync.WaitGroup
+
+	stateDir := config.StateDir
+	defer func() { config.StateDir = stateDir }()
+	config.StateDir = t.TempDir()
+	manager := GetContainerManager()
+	manager.load()
+	container := &Container{Id: "test", Type: config.DockerName}
+	manager.AddContainer(container)
+	defer manager.RemoveContainer("test")
+
+	run := func(work func(i int)) {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for i := 0; i < 100; i++ {
+				work(i)
+			}
+		}()
+	}
+	// the main loop
+	run(func(i int) {
+		container.lock.Lock()
+		mergeStats(container, makeStats([]usageStat{{i, float64(i)}}))
+		container.CPUUsageShort = float64(i)
+		container.CgroupCurrent.CPUSet.CPUS = fmt.Sprintf("0-%d", i % 4)
+		container.lock.Unlock()
+	})
+	// the cAdvisor workers
+	run(func(i int) {
+		statsRequest(container)
+	})
+	// the API
+	run(func(i int) {
+		manager.ListContainers(ContainerFilter{SortBy: "cores"})
+		json.Marshal(container)
+		container.lock.Lock()
+		container.CgroupRequest.DryRun = (i % 2) == 0
+		container.lock.Unlock()
+		manager.store()
+	})
+	run(func(i int) {
+		id := fmt.Sprintf("other%d", i)
+		manager.AddContainer(&Container{Id: id})
+		manager.IsContainerRegistered(id)
+		manager.GetContainers(id)
+		manager.RemoveContainer(id)
+	})
+	group.Wait()
+	if _, exist := manager.GetAllContainers()["test"]; !exist || (len(manager.GetAllContainers()) != 1) {
+		t.Errorf("expected only the test container, got %v", manager.GetAllContainers())
+	}
+}
+
+func TestListContainersOrder(t *testing.T) {
+	stateDir := config.StateDir
+	defer func() { config.StateDir = stateDir }()
+	config.StateDir = t.TempDir()
+	manager := GetContainerManager()
+	manager.load()
+	for _, container := range []*Container{
+		{Id: "c", CPUUsageShort: 10, CgroupCurrent: CgroupInfo{CPUSet: CgroupCPUSet{CPUS: "0-1"}}},
+		{Id: "a", CPUUsageShort: 10, CgroupCurrent: CgroupInfo{CPUSet: CgroupCPUSet{CPUS: "0"}}},
+		{Id: "d", CPUUsageShort: 5, CgroupCurrent: CgroupInfo{CPUSet: CgroupCPUSet{CPUS: "0-1"}}},
+		{Id: "b", CPUUsageShort: 20, CgroupCurrent: CgroupInfo{CPUSet: CgroupCPUSet{CPUS: "0"}}},
+	} {
+		manager.AddContainer(container)
+		defer manager.RemoveContainer(container.Id)
+	}
+	for _, test := range []struct {
+		filter		ContainerFilter
+		expected	string
+	}{
+		{ContainerFilter{}, "abcd"},
+		{ContainerFilter{Descending: true}, "dcba"},
+		{ContainerFilter{SortBy: "usage"}, "dacb"},
+		{ContainerFilter{SortBy: "usage", Descending: true}, "bcad"},
+		{ContainerFilter{SortBy: "cores"}, "abcd"},
+		{ContainerFilter{SortBy: "cores", Descending: true}, "dcba"},
+		{ContainerFilter{SortBy: "usage", Offset: 1, Limit: 2}, "ac"},
+	} {
+		for i := 0; i < 10; i++ {
+			summaries, _ := manager.ListContainers(test.filter)
+			ids := ""
+			for _, summary := range summaries {
+				ids += summary.Id
+			}
+			if ids != test.expected {
+				t.Fatalf("%+v: expected %s, got %s", test.filter, test.expected, ids)
+			}
+		}
+	}
+}
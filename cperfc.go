@@ -1,12 +1,10 @@
 package cperfc
 
 import (
-    "os"
-	"os/user"
-    "time"
+	"context"
+	"sort"
 
 	"cperfc/config"
-	"cperfc/cgroups"
 	log "cperfc/log"
 )
 
@@ -14,26 +12,36 @@ func init() {
 }
 
 func Run() int {
-    log.Logging()
-
-	if u, _ := user.Current(); u.Gid != "0" {
-		log.Error("Please run with root permissions")
-        log.Info("Terminates.")
-		finish(config.EXITNONROOT)
-	}
-
-	cgroups.Initialize()
-	NewContainerManager()
-	RESTfulAPIServe()
-	StartMonitoring()
-
-    for {
-		time.Sleep(time.Second)
+	daemon := NewDaemon()
+	daemon.HandleSignals = true
+	if err := daemon.Start(context.Background()); err != nil {
+		log.Error(err)
+		log.Info("Terminates.")
+		return ExitCode(err)
 	}
-    log.Info("Terminates.")
-	return config.EXITNORMAL
+	<- daemon.Done()
+	return ExitCode(daemon.Err())
 }
 
-func finish(returnCode int) {
-	os.Exit(returnCode)
+func ValidateConfig() error {
+	errs := &config.ConfigError{}
+	errs.Append(config.Validate())
+	for key, policy := range map[string]string{"policy.default": config.DefaultPolicy, "policy.docker": config.DockerPolicy, "policy.lxc": config.LxcPolicy} {
+		if (len(policy) > 0) && !IsScalingPolicy(policy) {
+			errs.Add("%s: unknown policy '%s', available policies are %s", key, policy, GetScalingPolicyNames())
+		}
+	}
+	for _, address := range config.Listeners {
+		listener, err := ParseAPIListener(address)
+		if err != nil {
+			errs.Add("listeners: %s", err)
+		} else if (listener.Scheme == "https") && (len(config.TLSCertFile) == 0) {
+			errs.Add("listeners: %s needs 'tls.cert' and 'tls.key'", listener)
+		}
+	}
+	if (len(config.SocketRole) > 0) && !IsRole(config.SocketRole) {
+		errs.Add("socket.role: unknown role '%s'", config.SocketRole)
+	}
+	sort.Strings(errs.Errors)
+	return errs.Err()
 }
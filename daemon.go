@@ -0,0 +1,249 @@
+package cperfc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+	"cperfc/log"
+)
+
+type DaemonError struct {
+	Code			int				// exit code of the command
+	Err				error
+}
+
+// Daemon runs cperfc inside a process, Run() is a Daemon with the signal handling.
+type Daemon struct {
+	HandleSignals	bool			// SIGTERM, SIGINT to shut down and SIGHUP to reload
+	RestoreCgroups	bool			// reset cgroups of the registered containers on shutdown
+	lock			sync.Mutex
+	started			bool
+	stopping		bool
+	signals			chan os.Signal
+	done			chan struct{}
+	err				error
+}
+
+var runningDaemon *Daemon
+var daemonLock sync.Mutex
+
+func init() {
+}
+
+func newDaemonError(code int, format string, args ...interface{}) *DaemonError {
+	return &DaemonError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+func (self *DaemonError)Error() string {
+	return self.Err.Error()
+}
+
+func ExitCode(err error) int {
+	if err == nil {
+		return config.EXITNORMAL
+	}
+	if daemonError, ok := err.(*DaemonError); ok {
+		return daemonError.Code
+	}
+	return config.EXITSHUTDOWN
+}
+
+func NewDaemon() *Daemon {
+	return &Daemon{RestoreCgroups: config.RestoreCgroups, done: make(chan struct{})}
+}
+
+// Start returns once the API and the monitoring are running, cancelling ctx shuts the daemon down.
+func (self *Daemon)Start(ctx context.Context) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.started {
+		return fmt.Errorf("the daemon is already started")
+	}
+	daemonLock.Lock()
+	defer daemonLock.Unlock()
+	if runningDaemon != nil {
+		return fmt.Errorf("another daemon is running in this process")
+	}
+	if err := log.Logging(); err != nil {
+		return newDaemonError(config.EXITLOG, "%s", err)
+	}
+	if u, err := user.Current(); (err != nil) || (u.Gid != "0") {
+		return newDaemonError(config.EXITNONROOT, "Please run with root permissions")
+	}
+	if err := StartAudit(); err != nil {
+		return newDaemonError(config.EXITLOG, "%s", err)
+	}
+	cgroups.Initialize()
+	NewContainerManager()
+	StartEventSubscribers()
+	if err := StartWebhooks(); err != nil {
+		log.Error(err)
+	}
+	if err := RESTfulAPIServe(); err != nil {
+		abortStart()
+		return err
+	}
+	if err := StartMonitoring(); err != nil {
+		abortStart()
+		return err
+	}
+	self.started = true
+	runningDaemon = self
+	if self.HandleSignals {
+		self.signals = make(chan os.Signal, 1)
+		signal.Notify(self.signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+		go self.handleSignals()
+	}
+	go func() {
+		select {
+		case <- ctx.Done():
+			self.stop(nil)
+		case <- self.done:
+		}
+	}()
+	return nil
+}
+
+// abortStart undoes what Start has started before a step fails, so that it can be started again.
+func abortStart() {
+	StopAPIListeners(context.Background())
+	StopWebhooks()
+	GetAuditLog().Close()
+}
+
+func (self *Daemon)handleSignals() {
+	for sig := range self.signals {
+		switch sig {
+		case syscall.SIGHUP:
+			log.Info("SIGHUP, reloading the configuration.")
+			if err := Reload(); err != nil {
+				log.Error(err)
+			}
+		default:
+			log.Infof("%s, shutting down.", sig)
+			self.stop(nil)
+		}
+	}
+}
+
+// stop shuts the daemon down in background, err is what Err() returns afterwards.
+func (self *Daemon)stop(err error) {
+	self.lock.Lock()
+	if self.err == nil {
+		self.err = err
+	}
+	self.lock.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout) * time.Second)
+		defer cancel()
+		self.Shutdown(ctx)
+	}()
+}
+
+// Shutdown drains the API, stops the monitoring, saves the registered containers,
+// restores their cgroups if asked and stops the webhooks. The daemon cannot be started again.
+func (self *Daemon)Shutdown(ctx context.Context) error {
+	var failed []string
+
+	self.lock.Lock()
+	if !self.started {
+		self.lock.Unlock()
+		return nil
+	}
+	if self.stopping {
+		self.lock.Unlock()
+		select {
+		case <- self.done:
+			return self.Err()
+		case <- ctx.Done():
+			return ctx.Err()
+		}
+	}
+	self.stopping = true
+	self.lock.Unlock()
+
+	log.Info("Shutting down.")
+	if self.signals != nil {
+		signal.Stop(self.signals)
+		close(self.signals)
+	}
+	if err := StopAPIListeners(ctx); err != nil {
+		failed = append(failed, err.Error())
+	}
+	if err := StopMonitoring(); err != nil {
+		failed = append(failed, fmt.Sprintf("failed to close the trace: %s", err))
+	}
+	manager := GetContainerManager()
+	if self.RestoreCgroups {
+		for _, container := range manager.GetAllContainers() {
+			for _, subSystem := range []string{config.CpuSetSubSystem, config.CpuSubSystem} {
+				entry := AuditEntry{Action: AuditCgroupWrite, Actor: auditController, Method: auditController, Container: container.Id, Reason: "restore " + subSystem + " on shutdown"}
+				if err := cgroups.ResetCgroupSubSystem(subSystem, container.Id); err != nil {
+					failed = append(failed, fmt.Sprintf("failed to restore %s of '%s': %s", subSystem, container.Id, err))
+					entry.Error = err.Error()
+				}
+				GetAuditLog().Record(entry)
+			}
+		}
+		log.Infof("cgroups of %d containers are restored.", len(manager.GetAllContainers()))
+	}
+	if ok, msg := manager.store(); !ok {
+		failed = append(failed, msg)
+	}
+	StopWebhooks()
+	if err := GetAuditLog().Close(); err != nil {
+		failed = append(failed, fmt.Sprintf("failed to close the audit log: %s", err))
+	}
+
+	self.lock.Lock()
+	if (self.err == nil) && (len(failed) > 0) {
+		self.err = newDaemonError(config.EXITSHUTDOWN, "%s", strings.Join(failed, "; "))
+	}
+	err := self.err
+	self.lock.Unlock()
+	daemonLock.Lock()
+	runningDaemon = nil
+	daemonLock.Unlock()
+	close(self.done)
+	log.Info("Terminates.")
+	return err
+}
+
+// Done is closed when the daemon has shut down.
+func (self *Daemon)Done() <-chan struct{} {
+	return self.done
+}
+
+func (self *Daemon)Err() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.err
+}
+
+func getRunningDaemon() *Daemon {
+	daemonLock.Lock()
+	defer daemonLock.Unlock()
+	return runningDaemon
+}
+
+func stopDaemon() {
+	if daemon := getRunningDaemon(); daemon != nil {
+		daemon.stop(nil)
+	}
+}
+
+func failDaemon(err *DaemonError) {
+	log.Error(err)
+	if daemon := getRunningDaemon(); daemon != nil {
+		daemon.stop(err)
+	}
+}
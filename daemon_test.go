@@ -0,0 +1,48 @@
+package cperfc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os/user"
+	"path"
+	"testing"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+)
+
+func TestStartFailureUndone(t *testing.T) {
+	if u, err := user.Current(); (err != nil) || (u.Gid != "0") {
+		t.Skip("the daemon needs root permissions")
+	}
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	saved, subSystems := config.Snapshot(), *cgroups.GetSubSystemManager()
+	defer func() {
+		config.Restore(saved)
+		*cgroups.GetSubSystemManager() = subSystems
+	}()
+	dir := t.TempDir()
+	config.StateDir = dir
+	config.AuditFile = path.Join(dir, "audit.log")
+	config.WebhookFile = path.Join(dir, "webhooks.json")
+	if err := ioutil.WriteFile(config.WebhookFile, []byte(`[{"url": "http://127.0.0.1:1/"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Listeners = []string{busy.Addr().String()}
+	daemon := NewDaemon()
+	if err := daemon.Start(context.Background()); err == nil {
+		daemon.Shutdown(context.Background())
+		t.Fatal("expected the busy listener to fail the start")
+	}
+	if GetAuditLog().file != nil {
+		t.Error("the audit log is left open")
+	}
+	if hooks := GetWebhookManager().GetWebhooks(); len(hooks) > 0 {
+		t.Errorf("%d webhooks are left running", len(hooks))
+	}
+}
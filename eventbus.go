@@ -0,0 +1,177 @@
+package cperfc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cperfc/log"
+)
+
+const (
+	EventSampleCollected = "sample_collected"
+	EventDecisionMade = "decision_made"
+	EventCgroupWritten = "cgroup_written"
+	EventCeilingReached = "ceiling_reached"
+	EventContainerRegistered = "container_registered"
+	EventContainerRemoved = "container_removed"
+	EventCAdvisorError = "cadvisor_error"
+	EventCAdvisorState = "cadvisor_state"		// the circuit breaker is {open, half_open, closed}
+)
+
+const eventQueueSize = 1024
+
+type Event struct {
+	Type			string			`json:"type"`
+	Timestamp		time.Time		`json:"timestamp"`
+	Id				string			`json:"id,omitempty"`			// container id
+	Container		*Container		`json:"-"`						// only safe to use in synchronous subscribers
+	Sample			*Sample			`json:"sample,omitempty"`
+	Action			*ScalingAction	`json:"action,omitempty"`
+	Write			*CgroupWrite	`json:"write,omitempty"`
+	Reason			string			`json:"reason,omitempty"`
+	Message			string			`json:"message,omitempty"`
+	Error			string			`json:"error,omitempty"`
+}
+
+type EventHandler func(event Event)
+
+type EventSubscription struct {
+	Name			string
+	Sync			bool
+	types			map[string]bool
+	handler			EventHandler
+	queue			chan Event
+	Handled			int64
+	Dropped			int64
+}
+
+type EventSubscriptionStats struct {
+	Name			string			`json:"name"`
+	Sync			bool			`json:"sync"`
+	Handled			int64			`json:"handled"`
+	Dropped			int64			`json:"dropped"`
+	Queued			int				`json:"queued"`
+}
+
+type EventBusStatus struct {
+	Metrics			EventMetrics				`json:"metrics"`
+	Subscribers		[]EventSubscriptionStats	`json:"subscribers"`
+}
+
+type EventBus struct {
+	lock			sync.RWMutex
+	subscriptions	[]*EventSubscription
+}
+
+var eventBus EventBus
+var eventSubscribers sync.Once
+
+func init() {
+}
+
+func GetEventBus() *EventBus {
+	return &eventBus
+}
+
+func StartEventSubscribers() {
+	eventSubscribers.Do(func() {
+		subscribeHistory()
+		subscribeTrace()
+		subscribeStream()
+		subscribeWebhooks()
+		subscribeEventLogging()
+		subscribeEventMetrics()
+		subscribeAudit()
+	})
+}
+
+func newEventSubscription(name string, types []string, handler EventHandler) *EventSubscription {
+	subscription := &EventSubscription{Name: name, types: make(map[string]bool), handler: handler}
+	for _, eventType := range types {
+		subscription.types[eventType] = true
+	}
+	return subscription
+}
+
+func (self *EventBus)Subscribe(name string, types []string, handler EventHandler) *EventSubscription {
+	subscription := newEventSubscription(name, types, handler)
+	subscription.queue = make(chan Event, eventQueueSize)
+	go func() {
+		for event := range subscription.queue {
+			subscription.handler(event)
+			atomic.AddInt64(&subscription.Handled, 1)
+		}
+	}()
+	self.add(subscription)
+	return subscription
+}
+
+func (self *EventBus)SubscribeSync(name string, types []string, handler EventHandler) *EventSubscription {
+	subscription := newEventSubscription(name, types, handler)
+	subscription.Sync = true
+	self.add(subscription)
+	return subscription
+}
+
+func (self *EventBus)add(subscription *EventSubscription) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.subscriptions = append(self.subscriptions, subscription)
+}
+
+func (self *EventBus)Unsubscribe(subscription *EventSubscription) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for i, candidate := range self.subscriptions {
+		if candidate == subscription {
+			self.subscriptions = append(self.subscriptions[:i], self.subscriptions[i + 1:]...)
+			if subscription.queue != nil {
+				close(subscription.queue)
+			}
+			return
+		}
+	}
+}
+
+func (self *EventBus)Publish(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = clock()
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for _, subscription := range self.subscriptions {
+		if (len(subscription.types) > 0) && !subscription.types[event.Type] {
+			continue
+		}
+		if subscription.Sync {
+			subscription.handler(event)
+			atomic.AddInt64(&subscription.Handled, 1)
+			continue
+		}
+		select {
+		case subscription.queue <- event:
+		default:
+			if atomic.AddInt64(&subscription.Dropped, 1) == 1 {
+				log.Warnf("Event bus: subscriber '%s' is too slow, events are dropped", subscription.Name)
+			}
+		}
+	}
+}
+
+func (self *EventBus)Stats() []EventSubscriptionStats {
+	var stats []EventSubscriptionStats
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for _, subscription := range self.subscriptions {
+		stats = append(stats, EventSubscriptionStats{
+			Name: subscription.Name,
+			Sync: subscription.Sync,
+			Handled: atomic.LoadInt64(&subscription.Handled),
+			Dropped: atomic.LoadInt64(&subscription.Dropped),
+			Queued: len(subscription.queue),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,62 @@
+package cperfc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"cperfc/log"
+)
+
+func init() {
+}
+
+func subscribeEventLogging() {
+	GetEventBus().Subscribe("log", nil, logEvent)
+}
+
+func logEvent(event Event) {
+	var outBuffer bytes.Buffer
+
+	switch event.Type {
+	case EventDecisionMade:
+		action := event.Action
+		for _, write := range action.Writes {
+			if action.DryRun {
+				outBuffer.WriteString(fmt.Sprintf("[%s] dry-run, would set %s %s -> %s: %s\n", action.Policy, write.File, write.Old, write.New, action.Reason))
+			} else {
+				outBuffer.WriteString(fmt.Sprintf("[%s] %s %s -> %s: %s\n", action.Policy, write.File, write.Old, write.New, action.Reason))
+			}
+		}
+		for _, write := range action.Cooldown {
+			outBuffer.WriteString(fmt.Sprintf("[%s] %s cooldown, hold %s -> %s: %s\n", action.Policy, write.File, write.Old, write.New, action.Reason))
+		}
+		if outBuffer.Len() > 0 {
+			log.Infof("%s: %s", event.Id, strings.TrimSpace(outBuffer.String()))
+		} else {
+			log.Debugf("%s: [%s] %s", event.Id, action.Policy, action.Reason)
+		}
+	case EventCgroupWritten:
+		if len(event.Error) > 0 {
+			log.Errorf("%s: failed to set %s %s: %s", event.Id, event.Write.File, event.Write.New, event.Error)
+		}
+	case EventCeilingReached:
+		log.Warnf("%s: %s", event.Id, event.Message)
+	case EventContainerRegistered:
+		log.Infof("%s: registered", event.Id)
+	case EventContainerRemoved:
+		log.Infof("%s: removed(%s)", event.Id, event.Reason)
+	case EventCAdvisorError:
+		if len(event.Id) > 0 {
+			log.Warnf("%s: cAdvisor error, %s: %s", event.Id, event.Message, event.Error)
+		} else {
+			log.Errorf("%s error: %s", event.Message, event.Error)
+		}
+	case EventCAdvisorState:
+		if event.Reason == CircuitOpen {
+			log.Errorf("%s: %s", event.Message, event.Error)
+		} else {
+			log.Info(event.Message)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cperfc
+
+import (
+	"sync"
+	"time"
+)
+
+type EventMetrics struct {
+	Events				map[string]int64	`json:"events"`				// number of events per type
+	Writes				int64				`json:"writes"`
+	WriteErrors			int64				`json:"write_errors"`
+	DryRunDecisions		int64				`json:"dry_run_decisions"`
+	LastCAdvisorError	string				`json:"last_cadvisor_error,omitempty"`
+	LastCAdvisorErrorAt	time.Time			`json:"last_cadvisor_error_at,omitempty"`
+	ContainerErrors		int64				`json:"container_errors"`		// failures to sample a container
+	CircuitOpened		int64				`json:"circuit_opened"`			// times the cAdvisor circuit is opened
+}
+
+var eventMetrics = EventMetrics{Events: make(map[string]int64)}
+var eventMetricsLock sync.RWMutex
+
+func init() {
+}
+
+func subscribeEventMetrics() {
+	GetEventBus().Subscribe("metrics", nil, func(event Event) {
+		eventMetricsLock.Lock()
+		defer eventMetricsLock.Unlock()
+		eventMetrics.Events[event.Type]++
+		switch event.Type {
+		case EventCgroupWritten:
+			eventMetrics.Writes++
+			if len(event.Error) > 0 {
+				eventMetrics.WriteErrors++
+			}
+		case EventDecisionMade:
+			if event.Action.DryRun && (len(event.Action.Writes) > 0) {
+				eventMetrics.DryRunDecisions++
+			}
+		case EventCAdvisorError:
+			eventMetrics.LastCAdvisorError = event.Error
+			eventMetrics.LastCAdvisorErrorAt = event.Timestamp
+			if len(event.Id) > 0 {
+				eventMetrics.ContainerErrors++
+			}
+		case EventCAdvisorState:
+			if event.Reason == CircuitOpen {
+				eventMetrics.CircuitOpened++
+			}
+		}
+	})
+}
+
+func GetEventMetrics() EventMetrics {
+	eventMetricsLock.RLock()
+	defer eventMetricsLock.RUnlock()
+	metrics := eventMetrics
+	metrics.Events = make(map[string]int64)
+	for eventType, count := range eventMetrics.Events {
+		metrics.Events[eventType] = count
+	}
+	return metrics
+}
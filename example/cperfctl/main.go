@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cperfc"
+)
+
+const (
+	EXITNORMAL = 0
+	EXITUSAGE = 1
+	EXITREQUEST = 2
+)
+
+var serverAddr = "http://localhost:8088"
+var outputFormat = "table"
+var watchInterval time.Duration
+var apiToken = os.Getenv("CPERFC_TOKEN")
+var httpClient = http.DefaultClient
+
+func init() {
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [options] <command> [arguments]
+
+Commands:
+	list [key=value...]			list registered containers, keys = {type, label, image, min_usage, max_usage, sort, order, offset, limit}
+					needs a cperfc with GET /v1/containers
+	host [managed={true|false}]		list containers on the host
+	register <cid> [policy]		register a container
+	unregister <cid>			unregister a container
+	isregistered <cid>			check whether a container is registered
+	status <cid>				show a registered container
+	set cpu <cid> key=value...		set cpu request, keys = {shares, thresh_min, thresh_max}
+	set cpuset <cid> key=value...	set cpuset request, keys = {cpus, thresh_min, thresh_max, min_cores, max_cores}
+	set policy <cid> <policy>		set scaling policy
+	reset {cpu|cpuset} <cid>		reset cgroup values of a container
+	pause					pause monitoring
+	resume					resume monitoring
+	process <pid>				find the container of a process
+
+Options:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.StringVar(&serverAddr, "addr", serverAddr, "address to cperfc RESTful API server, or unix:///path for a unix socket")
+	flag.StringVar(&outputFormat, "o", outputFormat, "output format = {table, json}")
+	flag.DurationVar(&watchInterval, "watch", 0, "refresh interval for 'status' and 'list', e.g. 5s")
+	flag.StringVar(&apiToken, "token", apiToken, "API token, $CPERFC_TOKEN by default")
+	caFile := flag.String("cacert", "", "CA file to verify the server certificate")
+	certFile := flag.String("cert", "", "client certificate file")
+	keyFile := flag.String("key", "", "client private key file")
+	flag.Parse()
+	if strings.HasPrefix(serverAddr, "unix://") {
+		socketPath := strings.TrimPrefix(serverAddr, "unix://")
+		dialer := net.Dialer{}
+		httpClient = &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}}}
+		serverAddr = "http://unix"
+	} else if (len(*caFile) > 0) || (len(*certFile) > 0) {
+		client, err := newTLSClient(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(EXITUSAGE)
+		}
+		httpClient = client
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(EXITUSAGE)
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if _, ok := err.(usageError); ok {
+			os.Exit(EXITUSAGE)
+		}
+		os.Exit(EXITREQUEST)
+	}
+	os.Exit(EXITNORMAL)
+}
+
+type usageError string
+
+func (self usageError)Error() string {
+	return string(self)
+}
+
+func needArgs(args []string, count int, text string) error {
+	if len(args) < count {
+		return usageError("Usage: " + text)
+	}
+	return nil
+}
+
+func run(args []string) error {
+	command := strings.ToLower(args[0])
+	args = args[1:]
+	switch command {
+	case "list":
+		query, err := parseQuery(args)
+		if err != nil {
+			return err
+		}
+		return watch(func() error {
+			return printList(call("GET", "/v1/containers?" + query.Encode(), nil))
+		})
+	case "host":
+		query, err := parseQuery(args)
+		if err != nil {
+			return err
+		}
+		return printHost(call("GET", "/v1/host/containers?" + query.Encode(), nil))
+	case "register":
+		if err := needArgs(args, 1, "register <cid> [policy]"); err != nil {
+			return err
+		}
+		request := cperfc.PolicyRequest{}
+		if len(args) > 1 {
+			request.Policy = args[1]
+		}
+		return printStatus(call("POST", "/v1/containers/" + args[0], request))
+	case "unregister":
+		if err := needArgs(args, 1, "unregister <cid>"); err != nil {
+			return err
+		}
+		return printDone(call("DELETE", "/v1/containers/" + args[0], nil))
+	case "isregistered":
+		if err := needArgs(args, 1, "isregistered <cid>"); err != nil {
+			return err
+		}
+		_, err := call("GET", "/v1/containers/" + args[0], nil)
+		if apiErr, ok := err.(*cperfc.APIError); ok && (apiErr.Status == http.StatusNotFound) {
+			fmt.Println(false)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fmt.Println(true)
+		return nil
+	case "status":
+		if err := needArgs(args, 1, "status <cid>"); err != nil {
+			return err
+		}
+		return watch(func() error {
+			return printStatus(call("GET", "/v1/containers/" + args[0], nil))
+		})
+	case "set":
+		return runSet(args)
+	case "reset":
+		if err := needArgs(args, 2, "reset {cpu|cpuset} <cid>"); err != nil {
+			return err
+		}
+		which := strings.ToLower(args[0])
+		if (which != "cpu") && (which != "cpuset") {
+			return usageError("Usage: reset {cpu|cpuset} <cid>")
+		}
+		return printDone(call("DELETE", "/v1/containers/" + args[1] + "/" + which, nil))
+	case "pause", "resume":
+		return printResult(call("POST", "/v1/control/" + command, nil))
+	case "process":
+		if err := needArgs(args, 1, "process <pid>"); err != nil {
+			return err
+		}
+		return printProcess(call("GET", "/v1/processes/" + args[0] + "/container", nil))
+	}
+	return usageError(fmt.Sprintf("Unknown command '%s'", command))
+}
+
+func runSet(args []string) error {
+	if err := needArgs(args, 2, "set {cpu|cpuset|policy} <cid> ..."); err != nil {
+		return err
+	}
+	which := strings.ToLower(args[0])
+	cid := args[1]
+	switch which {
+	case "policy":
+		if err := needArgs(args, 3, "set policy <cid> <policy>"); err != nil {
+			return err
+		}
+		return printDone(call("PUT", "/v1/containers/" + cid + "/policy", cperfc.PolicyRequest{Policy: args[2]}))
+	case "cpu", "cpuset":
+		body, err := parseKeyValues(args[2:])
+		if err != nil {
+			return err
+		}
+		return printDone(call("PUT", "/v1/containers/" + cid + "/" + which, body))
+	}
+	return usageError(fmt.Sprintf("Unknown target '%s'", which))
+}
+
+func parseQuery(args []string) (url.Values, error) {
+	query := url.Values{}
+	for _, arg := range args {
+		tokens := strings.SplitN(arg, "=", 2)
+		if len(tokens) != 2 {
+			return nil, usageError(fmt.Sprintf("Wrong argument '%s', use key=value", arg))
+		}
+		query.Add(tokens[0], tokens[1])
+	}
+	return query, nil
+}
+
+func parseKeyValues(args []string) (map[string]interface{}, error) {
+	body := make(map[string]interface{})
+	for _, arg := range args {
+		tokens := strings.SplitN(arg, "=", 2)
+		if len(tokens) != 2 {
+			return nil, usageError(fmt.Sprintf("Wrong argument '%s', use key=value", arg))
+		}
+		switch tokens[0] {
+		case "cpus", "shares":
+			body[tokens[0]] = tokens[1]
+		case "thresh_min", "thresh_max", "min_cores", "max_cores":
+			value, err := strconv.Atoi(tokens[1])
+			if err != nil {
+				return nil, usageError(fmt.Sprintf("Wrong number '%s'", arg))
+			}
+			body[tokens[0]] = value
+		default:
+			return nil, usageError(fmt.Sprintf("Unknown key '%s'", tokens[0]))
+		}
+	}
+	return body, nil
+}
+
+func newTLSClient(caFile string, certFile string, keyFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(caFile) > 0 {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+	}
+	if len(certFile) > 0 {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
+func call(method string, apiPath string, body interface{}) ([]byte, error) {
+	var reader *bytes.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request, err := http.NewRequest(method, strings.TrimRight(serverAddr, "/") + apiPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if len(apiToken) > 0 {
+		request.Header.Set("Authorization", "Bearer " + apiToken)
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		var envelope cperfc.APIErrorEnvelope
+		if (json.Unmarshal(b, &envelope) != nil) || (envelope.Error == nil) {
+			return nil, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(b)))
+		}
+		envelope.Error.Status = response.StatusCode
+		return nil, envelope.Error
+	}
+	return b, nil
+}
+
+func watch(show func() error) error {
+	if watchInterval <= 0 {
+		return show()
+	}
+	for {
+		if outputFormat != "json" {
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("Every %s: %s\n\n", watchInterval, time.Now().Format(time.Stamp))
+		}
+		if err := show(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+func printRaw(b []byte) bool {
+	if outputFormat == "json" || !json.Valid(b) {
+		fmt.Print(string(b))
+		return true
+	}
+	return false
+}
+
+func printResult(b []byte, err error) error {
+	var result cperfc.SimpleResult
+
+	if err != nil {
+		return err
+	}
+	if printRaw(b) {
+		return nil
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		fmt.Print(string(b))
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "RESULT\tDESCRIPTION")
+	fmt.Fprintf(writer, "%t\t%s\n", result.Result, result.Desc)
+	writer.Flush()
+	if !result.Result {
+		return fmt.Errorf("Request failed")
+	}
+	return nil
+}
+
+func printDone(b []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	if (outputFormat == "json") && (len(b) > 0) {
+		fmt.Print(string(b))
+		return nil
+	}
+	fmt.Println("done")
+	return nil
+}
+
+func printStatus(b []byte, err error) error {
+	var container cperfc.Container
+
+	if err != nil {
+		return err
+	}
+	if printRaw(b) {
+		return nil
+	}
+	if err := json.Unmarshal(b, &container); err != nil {
+		return err
+	}
+	if len(container.Id) == 0 {
+		return fmt.Errorf("The container is not registered")
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER\tTYPE\tCPUS\tSHARES\tPOLICY\tUSAGE(SHORT)\tUSAGE(LONG)\tTIMESTAMP")
+	policy := container.CgroupRequest.Policy
+	if len(policy) == 0 {
+		policy = "default"
+	}
+	fmt.Fprintf(writer, "%.12s\t%s\t%s\t%s\t%s\t%.2f%%\t%.2f%%\t%s\n", container.Id, container.Type, container.CgroupCurrent.CPUSet.CPUS, container.CgroupCurrent.CPU.Shares, policy, container.CPUUsageShort, container.CPUUsageLong, container.Timestamp.Format(time.Stamp))
+	writer.Flush()
+	return nil
+}
+
+func printList(b []byte, err error) error {
+	var list cperfc.ContainerList
+
+	if err != nil {
+		return err
+	}
+	if printRaw(b) {
+		return nil
+	}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER\tTYPE\tIMAGE\tCPUS\tSHARES\tPOLICY\tUSAGE(SHORT)\tUSAGE(LONG)")
+	for _, container := range list.Containers {
+		policy := container.CgroupRequest.Policy
+		if len(policy) == 0 {
+			policy = "default"
+		}
+		fmt.Fprintf(writer, "%.12s\t%s\t%s\t%s\t%s\t%s\t%.2f%%\t%.2f%%\n", container.Id, container.Type, container.Image, container.CgroupCurrent.CPUSet.CPUS, container.CgroupCurrent.CPU.Shares, policy, container.CPUUsageShort, container.CPUUsageLong)
+	}
+	writer.Flush()
+	fmt.Printf("\n%d of %d containers\n", len(list.Containers), list.Total)
+	return nil
+}
+
+func printHost(b []byte, err error) error {
+	var list []cperfc.HostContainer
+
+	if err != nil {
+		return err
+	}
+	if printRaw(b) {
+		return nil
+	}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER\tRUNTIME\tIMAGE\tCPUS\tMANAGED\tCGROUP")
+	for _, container := range list {
+		fmt.Fprintf(writer, "%.12s\t%s\t%s\t%s\t%t\t%s\n", container.Id, container.Runtime, container.Image, container.CPUS, container.Managed, container.CgroupPath)
+	}
+	writer.Flush()
+	return nil
+}
+
+func printProcess(b []byte, err error) error {
+	var container cperfc.Container
+
+	if err != nil {
+		return err
+	}
+	if printRaw(b) {
+		return nil
+	}
+	if err := json.Unmarshal(b, &container); err != nil {
+		return err
+	}
+	if len(container.Path) == 0 {
+		return fmt.Errorf("The process is not found")
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER\tTYPE\tPATH")
+	fmt.Fprintf(writer, "%s\t%s\t%s\n", container.Id, container.Type, container.Path)
+	writer.Flush()
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"cperfc"
@@ -11,6 +12,16 @@ func init() {
 }
 
 func main() {
-	config.ParseCommandLine()
+	errs := &config.ConfigError{}
+	errs.Append(config.ParseCommandLine())
+	errs.Append(cperfc.ValidateConfig())
+	if err := errs.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(config.EXITCONFIG)
+	}
+	if config.PrintConfig {
+		config.Print(os.Stdout)
+		os.Exit(config.EXITNORMAL)
+	}
 	os.Exit(cperfc.Run())
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cperfc"
+	"cperfc/config"
+)
+
+const (
+	EXITIO = 1
+	EXITUSAGE = 2
+)
+
+func init() {
+}
+
+func main() {
+	var simulation cperfc.SimulationConfig
+	var interval int
+	var output string
+	var verbose bool
+
+	flag.StringVar(&simulation.TraceFile, "trace", "", "trace file recorded by 'cperfc -trace'")
+	flag.IntVar(&simulation.MachineCores, "cores", 0, "number of machine cores, 0 to use the recorded one")
+	flag.IntVar(&interval, "interval", config.MainLoopInterval, "virtual interval for monitoring in second")
+	flag.IntVar(&config.ScalingCooldown, "cooldown", config.ScalingCooldown, "minimum seconds between two scaling actions of a container")
+	flag.IntVar(&config.HistoryLength, "history", config.HistoryLength, "number of samples kept per container")
+	flag.StringVar(&simulation.Policy, "policy", "", "scaling policy for all containers, empty to use the recorded ones")
+	flag.StringVar(&output, "output", "text", "output format = {text, json}")
+	flag.BoolVar(&verbose, "v", false, "print every action")
+	flag.Parse()
+	if len(simulation.TraceFile) == 0 {
+		flag.Usage()
+		os.Exit(EXITUSAGE)
+	}
+	config.MainLoopInterval = interval
+	simulation.Interval = time.Duration(interval) * time.Second
+
+	report, err := cperfc.Simulate(simulation)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(EXITIO)
+	}
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(report)
+		return
+	}
+
+	fmt.Printf("%d cores, %s - %s (%s)\n", report.MachineCores, report.Start.Format(time.Stamp), report.End.Format(time.Stamp), report.End.Sub(report.Start))
+	if verbose {
+		for _, action := range report.Actions {
+			var writes []string
+			for _, write := range action.Writes {
+				writes = append(writes, fmt.Sprintf("%s %s -> %s", write.File, write.Old, write.New))
+			}
+			fmt.Printf("%s %.12s [%s] %s: %s\n", action.Timestamp.Format(time.Stamp), action.Id, action.Policy, strings.Join(writes, ", "), action.Reason)
+		}
+	}
+	var ids []string
+	for id := range report.Containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER\tPOLICY\tSAMPLES\tACTIONS\tCORES\tOVER THRESHOLD(s)\tCORE-SECONDS\tUSED\tUNMET")
+	for _, id := range ids {
+		container := report.Containers[id]
+		fmt.Fprintf(writer, "%.12s\t%s\t%d\t%d\t%d-%d\t%.1f\t%.1f\t%.1f\t%.1f\n", container.Id, container.Policy, container.Samples, container.Actions, container.MinCores, container.MaxCores, container.OverThreshold, container.CoreSeconds, container.UsedCoreSeconds, container.UnmetCoreSeconds)
+	}
+	writer.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"cperfc"
+)
+
+func init() {
+}
+
+func main() {
+	var addr string
+	var secret string
+	var fail int64
+	var received int64
+
+	flag.StringVar(&addr, "addr", ":9090", "address to listen for webhooks")
+	flag.StringVar(&secret, "secret", "", "secret to verify the payload signature")
+	flag.Int64Var(&fail, "fail", 0, "respond '503 Service Unavailable' to the first N deliveries to exercise retries")
+	flag.Parse()
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var event cperfc.WebhookEvent
+
+		count := atomic.AddInt64(&received, 1)
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (len(secret) > 0) && !cperfc.VerifyWebhookSignature(secret, body, r.Header.Get(cperfc.WebhookSignatureHeader)) {
+			fmt.Printf("#%d %s: bad signature '%s'\n", count, r.Header.Get("X-Cperfc-Delivery"), r.Header.Get(cperfc.WebhookSignatureHeader))
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if count <= fail {
+			fmt.Printf("#%d %s: failed on purpose\n", count, r.Header.Get("X-Cperfc-Delivery"))
+			http.Error(w, "failed on purpose", http.StatusServiceUnavailable)
+			return
+		}
+		if err := json.Unmarshal(body, &event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("#%d %s %s %s %s\n", count, event.Timestamp.Format("15:04:05"), event.Type, event.Container, event.Message)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	fmt.Printf("Listening webhooks on %s\n", addr)
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
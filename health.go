@@ -0,0 +1,233 @@
+package cperfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+)
+
+type HealthCheck struct {
+	OK				bool			`json:"ok"`
+	Message			string			`json:"message,omitempty"`
+}
+
+type ReadyStatus struct {
+	Ready			bool					`json:"ready"`
+	Checks			map[string]HealthCheck	`json:"checks"`		// {cadvisor, cgroups, state, loop}
+}
+
+type LoopStatus struct {
+	Running				bool			`json:"running"`			// false if paused
+	Interval			int				`json:"interval"`
+	Iterations			int64			`json:"iterations"`
+	Skipped				int64			`json:"skipped"`			// iterations skipped while the cAdvisor circuit is open
+	Overruns			int64			`json:"overruns"`			// iterations longer than the interval
+	MissedTicks			int64			`json:"missed_ticks"`		// iterations not started for overruns
+	LastOverrunAt		time.Time		`json:"last_overrun_at,omitempty"`
+	LastStart			time.Time		`json:"last_start,omitempty"`
+	LastDuration		float64			`json:"last_duration"`		// second
+	MaxDuration			float64			`json:"max_duration"`
+	AverageDuration		float64			`json:"average_duration"`
+	CAdvisorReachable	bool			`json:"cadvisor_reachable"`
+	CAdvisorCheckedAt	time.Time		`json:"cadvisor_checked_at,omitempty"`
+	LastError			string			`json:"last_error,omitempty"`
+	LastErrorAt			time.Time		`json:"last_error_at,omitempty"`
+}
+
+type BuildInfo struct {
+	Version			string			`json:"version"`
+	GoVersion		string			`json:"go_version"`
+	Path			string			`json:"path,omitempty"`
+	Revision		string			`json:"revision,omitempty"`
+	Time			string			`json:"time,omitempty"`
+	Modified		bool			`json:"modified,omitempty"`
+}
+
+type DebugStatus struct {
+	Build			BuildInfo		`json:"build"`
+	StartedAt		time.Time		`json:"started_at"`
+	Uptime			float64			`json:"uptime"`			// second
+	Goroutines		int				`json:"goroutines"`
+	Containers		int				`json:"containers"`
+	DryRun			bool			`json:"dry_run"`
+	Listeners		[]string		`json:"listeners"`
+	Pprof			bool			`json:"pprof"`
+	Loop			LoopStatus		`json:"loop"`
+	CAdvisor		CAdvisorStatus	`json:"cadvisor"`
+	Events			EventBusStatus	`json:"events"`
+}
+
+// Version is set by '-ldflags "-X cperfc.Version=<version>"'.
+var Version = "dev"
+
+var startedAt = time.Now()
+var loopStatus LoopStatus
+var loopStatusLock sync.RWMutex
+
+func init() {
+}
+
+func setLoopRunning(running bool) {
+	loopStatusLock.Lock()
+	defer loopStatusLock.Unlock()
+	loopStatus.Running = running
+}
+
+// recordLoopIteration records an iteration, which missed 'missed' ticks if it overran the interval.
+func recordLoopIteration(start time.Time, duration time.Duration, skipped bool, missed int) {
+	loopStatusLock.Lock()
+	defer loopStatusLock.Unlock()
+	seconds := duration.Seconds()
+	loopStatus.Iterations++
+	if skipped {
+		loopStatus.Skipped++
+	}
+	if missed > 0 {
+		loopStatus.Overruns++
+		loopStatus.MissedTicks += int64(missed)
+		loopStatus.LastOverrunAt = start
+	}
+	loopStatus.LastStart = start
+	loopStatus.LastDuration = seconds
+	if seconds > loopStatus.MaxDuration {
+		loopStatus.MaxDuration = seconds
+	}
+	loopStatus.AverageDuration += (seconds - loopStatus.AverageDuration) / float64(loopStatus.Iterations)
+}
+
+// recordCAdvisor records the result of reaching cAdvisor, err of a container does not make it unreachable.
+func recordCAdvisor(err error, reachable bool) {
+	loopStatusLock.Lock()
+	defer loopStatusLock.Unlock()
+	loopStatus.CAdvisorReachable = reachable
+	loopStatus.CAdvisorCheckedAt = time.Now()
+	if err != nil {
+		loopStatus.LastError = err.Error()
+		loopStatus.LastErrorAt = loopStatus.CAdvisorCheckedAt
+	}
+}
+
+func GetLoopStatus() LoopStatus {
+	loopStatusLock.RLock()
+	defer loopStatusLock.RUnlock()
+	status := loopStatus
+	status.Interval = config.MainLoopInterval
+	return status
+}
+
+func GetReadyStatus() ReadyStatus {
+	loop := GetLoopStatus()
+	status := ReadyStatus{Ready: true, Checks: make(map[string]HealthCheck)}
+	cAdvisor := GetCAdvisor().Status()
+	status.Checks["cadvisor"] = HealthCheck{OK: loop.CAdvisorReachable, Message: config.CAdvisorAddr}
+	if cAdvisor.State != CircuitClosed {
+		status.Checks["cadvisor"] = HealthCheck{Message: fmt.Sprintf("circuit is %s, retry at %s: %s", cAdvisor.State, cAdvisor.RetryAt.Format(time.RFC3339), cAdvisor.LastError)}
+	} else if !loop.CAdvisorReachable && (len(loop.LastError) > 0) {
+		status.Checks["cadvisor"] = HealthCheck{Message: loop.LastError}
+	}
+	if subSystems := cgroups.GetSubSystemManager().GetAllSubSystems(); len(subSystems) > 0 {
+		status.Checks["cgroups"] = HealthCheck{OK: true, Message: strings.Join(subSystems, ",")}
+	} else {
+		status.Checks["cgroups"] = HealthCheck{Message: "no cpu or cpuset subsystem"}
+	}
+	status.Checks["state"] = HealthCheck{OK: GetContainerManager().loaded, Message: GetContainerManager().loadMessage}
+	// paused is not unready, or '/control/resume' would be unreachable behind a load balancer
+	if loop.Running {
+		status.Checks["loop"] = HealthCheck{OK: true, Message: "running"}
+	} else {
+		status.Checks["loop"] = HealthCheck{OK: true, Message: "paused"}
+	}
+	for _, check := range status.Checks {
+		status.Ready = status.Ready && check.OK
+	}
+	return status
+}
+
+func GetBuildInfo() BuildInfo {
+	build := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	build.Path = info.Path
+	if (Version == "dev") && (len(info.Main.Version) > 0) && (info.Main.Version != "(devel)") {
+		build.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+func GetDebugStatus() DebugStatus {
+	status := DebugStatus{Build: GetBuildInfo(), StartedAt: startedAt, Uptime: time.Since(startedAt).Seconds(), Goroutines: runtime.NumGoroutine(), DryRun: config.DryRun, Listeners: []string{}, Pprof: config.Pprof, Loop: GetLoopStatus(), CAdvisor: GetCAdvisor().Status()}
+	status.Containers = len(GetContainerManager().GetAllContainers())
+	for _, listener := range GetAPIListeners() {
+		status.Listeners = append(status.Listeners, listener.String())
+	}
+	status.Events = EventBusStatus{Metrics: GetEventMetrics(), Subscribers: GetEventBus().Stats()}
+	return status
+}
+
+// writeProbe is writeV1 without logging, probes come every few seconds.
+func writeProbe(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func restfulHealthz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, http.StatusOK, SimpleResult{Result: true, Desc: "alive"})
+}
+
+func restfulReadyz(w http.ResponseWriter, r *http.Request) {
+	status := GetReadyStatus()
+	if status.Ready {
+		writeProbe(w, http.StatusOK, status)
+	} else {
+		writeProbe(w, http.StatusServiceUnavailable, status)
+	}
+}
+
+func restfulDebugStatus(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, r, http.StatusOK, GetDebugStatus())
+}
+
+// restfulPprof serves net/http/pprof under /debug/pprof/ if it is enabled by 'debug.pprof'.
+func restfulPprof(w http.ResponseWriter, r *http.Request) {
+	config.RLock()
+	enabled := config.Pprof
+	config.RUnlock()
+	if !enabled {
+		writeV1Error(w, r, newAPIError(http.StatusNotFound, ErrorNotFound, "pprof is disabled, enable 'debug.pprof'"))
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
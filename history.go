@@ -0,0 +1,130 @@
+package cperfc
+
+import (
+	"sync"
+	"time"
+)
+
+type Sample struct {
+	Timestamp			time.Time		`json:"timestamp"`
+	CPUUsage			float64			`json:"cpu_usage"`			// percent of the allotted cores
+	Cores				int				`json:"cores"`
+	CoresUsed			float64			`json:"cores_used"`
+	CPUS				string			`json:"cpus"`
+	Shares				uint64			`json:"shares"`
+	ThrottledPeriods	uint64			`json:"throttled_periods"`
+	ThrottledTime		uint64			`json:"throttled_time"`		// nanoseconds
+}
+
+type SampleHistory struct {
+	lock			sync.RWMutex
+	samples			[]Sample
+	head			int
+	count			int
+}
+
+func init() {
+}
+
+func subscribeHistory() {
+	GetEventBus().SubscribeSync("history", []string{EventSampleCollected}, func(event Event) {
+		if (event.Container != nil) && (event.Container.History != nil) {
+			event.Container.History.Add(*event.Sample)
+		}
+	})
+}
+
+func NewSampleHistory(length int) *SampleHistory {
+	if length < 1 {
+		length = 1
+	}
+	return &SampleHistory{samples: make([]Sample, length)}
+}
+
+func (self *SampleHistory)Add(sample Sample) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.samples[self.head] = sample
+	self.head = (self.head + 1) % len(self.samples)
+	if self.count < len(self.samples) {
+		self.count++
+	}
+}
+
+func (self *SampleHistory)Len() int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.count
+}
+
+func (self *SampleHistory)Last() (Sample, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if self.count == 0 {
+		return Sample{}, false
+	}
+	return self.samples[(self.head + len(self.samples) - 1) % len(self.samples)], true
+}
+
+func (self *SampleHistory)GetAll() []Sample {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	all := make([]Sample, 0, self.count)
+	start := (self.head + len(self.samples) - self.count) % len(self.samples)
+	for i := 0; i < self.count; i++ {
+		all = append(all, self.samples[(start + i) % len(self.samples)])
+	}
+	return all
+}
+
+func (self *SampleHistory)GetRange(from time.Time, to time.Time) []Sample {
+	var samples []Sample
+
+	for _, sample := range self.GetAll() {
+		if !from.IsZero() && sample.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && sample.Timestamp.After(to) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func Downsample(samples []Sample, step time.Duration) []Sample {
+	var downsampled []Sample
+	var bucket []Sample
+
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+	flush := func() {
+		if len(bucket) == 0 {
+			return
+		}
+		merged := bucket[len(bucket) - 1]
+		usage := 0.0
+		used := 0.0
+		cores := 0
+		for _, sample := range bucket {
+			usage += sample.CPUUsage
+			used += sample.CoresUsed
+			cores += sample.Cores
+		}
+		merged.Timestamp = bucket[0].Timestamp.Truncate(step)
+		merged.CPUUsage = usage / float64(len(bucket))
+		merged.CoresUsed = used / float64(len(bucket))
+		merged.Cores = (cores + len(bucket) / 2) / len(bucket)
+		downsampled = append(downsampled, merged)
+		bucket = nil
+	}
+	for _, sample := range samples {
+		if len(bucket) > 0 && !sample.Timestamp.Truncate(step).Equal(bucket[0].Timestamp.Truncate(step)) {
+			flush()
+		}
+		bucket = append(bucket, sample)
+	}
+	flush()
+	return downsampled
+}
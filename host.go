@@ -0,0 +1,65 @@
+package cperfc
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	cAdvisorInfo "github.com/google/cadvisor/info/v1"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+)
+
+type HostContainer struct {
+	Id				string			`json:"id"`
+	Runtime			string			`json:"runtime"`
+	CgroupPath		string			`json:"cgroup_path"`
+	CPUS			string			`json:"cpus"`
+	Image			string			`json:"image"`
+	Names			[]string		`json:"names"`
+	Managed			bool			`json:"managed"`
+}
+
+func init() {
+}
+
+func GetHostContainers() []HostContainer {
+	hostContainers := make(map[string]*HostContainer)
+	for _, container := range cgroups.GetAllContainers(config.CpuSetSubSystem) {
+		cpus, _ := cgroups.ReadCgroupValue(container.Path, "cpuset.cpus")
+		hostContainers[container.Id] = &HostContainer{Id: container.Id, Runtime: container.Type, CgroupPath: container.Path, CPUS: cpus}
+	}
+
+	request := cAdvisorInfo.ContainerInfoRequest{NumStats: 0}
+	subcontainers, err := GetCAdvisor().SubcontainersInfo("/", &request)
+	if err == nil {
+		for _, subcontainer := range subcontainers {
+			parent, id := path.Split(subcontainer.Name)
+			runtime := path.Base(parent)
+			if strings.HasPrefix(id, config.DockerName + "-") && strings.HasSuffix(id, ".scope") {
+				id = strings.TrimSuffix(strings.TrimPrefix(id, config.DockerName + "-"), ".scope")
+				runtime = config.DockerName
+			}
+			if (runtime != config.DockerName) && (runtime != config.LxcName) {
+				continue
+			}
+			hostContainer, exist := hostContainers[id]
+			if !exist {
+				hostContainer = &HostContainer{Id: id, Runtime: runtime, CPUS: subcontainer.Spec.Cpu.Mask}
+				hostContainers[id] = hostContainer
+			}
+			hostContainer.Image = subcontainer.Spec.Image
+			hostContainer.Names = subcontainer.Aliases
+		}
+	}
+
+	manager := GetContainerManager()
+	list := []HostContainer{}
+	for _, hostContainer := range hostContainers {
+		hostContainer.Managed = manager.IsContainerRegistered(hostContainer.Id)
+		list = append(list, *hostContainer)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
@@ -0,0 +1,365 @@
+package cperfc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cperfc/config"
+	"cperfc/log"
+)
+
+type APIListener struct {
+	Scheme			string			// {http, https, unix}
+	Address			string			// host:port or socket path
+	settings		string			// TLS or socket settings the listener was opened with
+	server			*http.Server
+	listener		net.Listener
+}
+
+type unixSocketContextKey struct {
+}
+
+type certificateReloader struct {
+	certFile		string
+	keyFile			string
+	lock			sync.Mutex
+	certificate		*tls.Certificate
+	modTime			time.Time
+	checked			time.Time
+}
+
+const certificateCheckInterval = 5 * time.Second
+
+var apiListeners []*APIListener
+var apiHandler http.Handler
+var apiContext context.Context
+var apiCancel context.CancelFunc
+
+func init() {
+}
+
+func ParseAPIListener(address string) (*APIListener, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if len(u.Host) == 0 {
+			return nil, fmt.Errorf("listener '%s': no address", address)
+		}
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("listener '%s': %s", address, err)
+		}
+		return &APIListener{Scheme: u.Scheme, Address: u.Host}, nil
+	case "unix":
+		if len(u.Host + u.Path) == 0 {
+			return nil, fmt.Errorf("listener '%s': no socket path", address)
+		}
+		return &APIListener{Scheme: u.Scheme, Address: u.Host + u.Path}, nil
+	}
+	return nil, fmt.Errorf("listener '%s': unknown scheme '%s' = {http, https, unix}", address, u.Scheme)
+}
+
+func (self *APIListener)String() string {
+	return self.Scheme + "://" + self.Address
+}
+
+func StartAPIListeners(handler http.Handler) error {
+	apiHandler = handler
+	apiContext, apiCancel = context.WithCancel(context.Background())
+	listeners, err := openAPIListeners(nil)
+	if err != nil {
+		apiCancel()
+		return err
+	}
+	apiListeners = listeners
+	return nil
+}
+
+// StopAPIListeners stops accepting requests and waits for the running ones until ctx is done.
+func StopAPIListeners(ctx context.Context) error {
+	var failed []string
+
+	if apiCancel != nil {
+		apiCancel()
+	}
+	for _, listener := range apiListeners {
+		if err := listener.server.Shutdown(ctx); err != nil {
+			listener.server.Close()
+			failed = append(failed, fmt.Sprintf("%s: %s", listener, err))
+		}
+		log.Infof("API listener %s is closed.", listener)
+	}
+	apiListeners = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to drain API requests: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// ReloadAPIListeners keeps the listeners whose settings are unchanged and replaces the others,
+// nothing before the API is served.
+func ReloadAPIListeners() error {
+	if apiContext == nil {
+		return nil
+	}
+	listeners, err := openAPIListeners(apiListeners)
+	if err != nil {
+		return err
+	}
+	apiListeners = listeners
+	return nil
+}
+
+func configuredAPIListeners() ([]*APIListener, error) {
+	var listeners []*APIListener
+
+	addresses := config.Listeners
+	if len(addresses) == 0 {
+		addresses = []string{":" + strconv.Itoa(config.ListeningPort)}
+		if len(config.TLSCertFile) > 0 {
+			addresses[0] = "https://" + addresses[0]
+		}
+	}
+	for _, address := range addresses {
+		listener, err := ParseAPIListener(address)
+		if err != nil {
+			return nil, err
+		}
+		switch listener.Scheme {
+		case "https":
+			listener.settings = strings.Join([]string{config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile}, "|")
+		case "unix":
+			listener.settings = strings.Join([]string{config.SocketMode, config.SocketOwner, config.SocketGroup}, "|")
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+func openAPIListeners(running []*APIListener) ([]*APIListener, error) {
+	var listeners []*APIListener
+	var opened []*APIListener
+	var closed []*APIListener
+
+	wanted, err := configuredAPIListeners()
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[*APIListener]bool)
+	for i, listener := range wanted {
+		for _, candidate := range running {
+			if (candidate.String() == listener.String()) && (candidate.settings == listener.settings) {
+				wanted[i] = candidate
+				kept[candidate] = true
+			}
+		}
+	}
+	for _, listener := range running {
+		if !kept[listener] {
+			listener.close()
+			closed = append(closed, listener)
+		}
+	}
+	for _, listener := range wanted {
+		if kept[listener] {
+			listeners = append(listeners, listener)
+			continue
+		}
+		if err := listener.listen(apiHandler); err != nil {
+			for _, listener := range opened {
+				listener.close()
+			}
+			for _, listener := range closed {
+				if err := listener.listen(apiHandler); err != nil {
+					log.Errorf("Failed to restore API listener %s: %s", listener, err)
+				} else {
+					go listener.serve()
+				}
+			}
+			return nil, fmt.Errorf("%s: %s", listener, err)
+		}
+		opened = append(opened, listener)
+		listeners = append(listeners, listener)
+	}
+	for _, listener := range opened {
+		go listener.serve()
+	}
+	return listeners, nil
+}
+
+func GetAPIListeners() []*APIListener {
+	return apiListeners
+}
+
+func (self *APIListener)close() {
+	self.server.Close()
+	self.listener.Close()
+	log.Infof("API listener %s is closed.", self)
+}
+
+func (self *APIListener)listen(handler http.Handler) error {
+	var err error
+
+	self.server = &http.Server{Handler: handler}
+	self.server.BaseContext = func(net.Listener) context.Context {
+		return apiContext
+	}
+	switch self.Scheme {
+	case "unix":
+		if err := os.Remove(self.Address); (err != nil) && !os.IsNotExist(err) {
+			return err
+		}
+		self.listener, err = net.Listen("unix", self.Address)
+		if err != nil {
+			return err
+		}
+		if err := setSocketPermission(self.Address); err != nil {
+			self.listener.Close()
+			return err
+		}
+		self.server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, unixSocketContextKey{}, true)
+		}
+	case "https":
+		tlsConfig, err := newTLSConfig()
+		if err != nil {
+			return err
+		}
+		self.server.TLSConfig = tlsConfig
+		listener, err := net.Listen("tcp", self.Address)
+		if err != nil {
+			return err
+		}
+		self.listener = tls.NewListener(listener, tlsConfig)
+	default:
+		self.listener, err = net.Listen("tcp", self.Address)
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("API listener %s is ready.", self)
+	return nil
+}
+
+func (self *APIListener)serve() {
+	err := self.server.Serve(self.listener)
+	if err != http.ErrServerClosed {
+		failDaemon(newDaemonError(config.EXITPORT, "API listener %s: %s", self, err))
+	}
+}
+
+func setSocketPermission(socketPath string) error {
+	mode, err := strconv.ParseUint(config.SocketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("wrong socket mode '%s': %s", config.SocketMode, err)
+	}
+	if err := os.Chmod(socketPath, os.FileMode(mode)); err != nil {
+		return err
+	}
+	uid, gid := -1, -1
+	if len(config.SocketOwner) > 0 {
+		owner, err := user.Lookup(config.SocketOwner)
+		if err != nil {
+			owner, err = user.LookupId(config.SocketOwner)
+		}
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(owner.Uid)
+	}
+	if len(config.SocketGroup) > 0 {
+		group, err := user.LookupGroup(config.SocketGroup)
+		if err != nil {
+			group, err = user.LookupGroupId(config.SocketGroup)
+		}
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+	if (uid < 0) && (gid < 0) {
+		return nil
+	}
+	return os.Chown(socketPath, uid, gid)
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	if (len(config.TLSCertFile) == 0) || (len(config.TLSKeyFile) == 0) {
+		return nil, fmt.Errorf("HTTPS needs both -tls-cert and -tls-key")
+	}
+	reloader := &certificateReloader{certFile: config.TLSCertFile, keyFile: config.TLSKeyFile}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.GetCertificate}
+	if len(config.TLSClientCAFile) > 0 {
+		b, err := ioutil.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate in %s", config.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+func (self *certificateReloader)load() error {
+	certInfo, err := os.Stat(self.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(self.keyFile)
+	if err != nil {
+		return err
+	}
+	modTime := certInfo.ModTime()
+	if keyInfo.ModTime().After(modTime) {
+		modTime = keyInfo.ModTime()
+	}
+	if (self.certificate != nil) && !modTime.After(self.modTime) {
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(self.certFile, self.keyFile)
+	if err != nil {
+		return err
+	}
+	if self.certificate != nil {
+		log.Infof("TLS certificate %s is reloaded.", self.certFile)
+	}
+	self.certificate = &certificate
+	self.modTime = modTime
+	return nil
+}
+
+func (self *certificateReloader)GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if now := time.Now(); now.Sub(self.checked) >= certificateCheckInterval {
+		self.checked = now
+		if err := self.load(); err != nil {
+			log.Warnf("Failed to reload TLS certificate %s, keep the previous one: %s", self.certFile, err)
+		}
+	}
+	return self.certificate, nil
+}
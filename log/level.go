@@ -0,0 +1,92 @@
+package log
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type Levels struct {
+	Default			string				`json:"default"`
+	Modules			map[string]string	`json:"modules"`		// module or module prefix -> level
+	Seen			[]string			`json:"seen,omitempty"`	// modules which have logged
+}
+
+var defaultLevel = log.InfoLevel
+var moduleLevels = map[string]log.Level{}
+var seenModules = map[string]bool{}
+var levelLock sync.RWMutex
+
+func init() {
+}
+
+func setLevels(level log.Level, modules map[string]log.Level) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	defaultLevel = level
+	moduleLevels = modules
+}
+
+// moduleLevel is the level of the longest key matching the module, 'restful' matches 'restful_v1'.
+func moduleLevel(module string) log.Level {
+	level := defaultLevel
+	matched := -1
+	for key, keyLevel := range moduleLevels {
+		if ((key == module) || strings.HasPrefix(module, key + "_")) && (len(key) > matched) {
+			level = keyLevel
+			matched = len(key)
+		}
+	}
+	return level
+}
+
+func IsEnabled(module string, level log.Level) bool {
+	levelLock.RLock()
+	enabled := level <= moduleLevel(module)
+	seen := seenModules[module]
+	levelLock.RUnlock()
+	if !seen {
+		levelLock.Lock()
+		seenModules[module] = true
+		levelLock.Unlock()
+	}
+	return enabled
+}
+
+func GetLevels() Levels {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	levels := Levels{Default: defaultLevel.String(), Modules: make(map[string]string)}
+	for module, level := range moduleLevels {
+		levels.Modules[module] = level.String()
+	}
+	for module := range seenModules {
+		levels.Seen = append(levels.Seen, module)
+	}
+	sort.Strings(levels.Seen)
+	return levels
+}
+
+// SetLevel changes the level of a module at runtime, the default level if module is empty.
+// An empty level removes the level of the module.
+func SetLevel(module string, level string) error {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	if (len(level) == 0) && (len(module) > 0) {
+		delete(moduleLevels, module)
+		return nil
+	}
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("unknown level '%s' = {info, warning, fatal, error, panic, debug}", level)
+	}
+	if len(module) == 0 {
+		defaultLevel = parsed
+		return nil
+	}
+	moduleLevels[module] = parsed
+	return nil
+}
@@ -2,7 +2,9 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"runtime"
 	"strings"
 
@@ -13,7 +15,8 @@ import (
 func init() {
 }
 
-func Logging() {
+// Logging applies the log settings in config, the previous output is kept if the new one fails.
+func Logging() error {
 	if config.LogFormat == "json" {
 		log.SetFormatter(&log.JSONFormatter{})
 	} else {
@@ -26,88 +29,171 @@ func Logging() {
 	if err != nil {
 		level = log.InfoLevel
 	}
-    log.SetOutput(os.Stderr)
-	log.SetLevel(level)
+	modules := make(map[string]log.Level)
+	for _, module := range config.LogModules {
+		parts := strings.SplitN(module, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("log.modules: '%s' is not '<module>=<level>'", module)
+		}
+		moduleLevel, err := log.ParseLevel(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return fmt.Errorf("log.modules: %s", err)
+		}
+		modules[strings.TrimSpace(parts[0])] = moduleLevel
+	}
+	if err := setOutput(); err != nil {
+		return err
+	}
+	if err := setSyslog(config.LogSyslog); err != nil {
+		return err
+	}
+	// modules are filtered here, logrus passes everything
+	log.SetLevel(log.DebugLevel)
+	setLevels(level, modules)
+	return nil
+}
+
+func setOutput() error {
+	if len(config.LogFile) == 0 {
+		switchOutput(os.Stderr)
+		return nil
+	}
+	if (logFile != nil) && (logFile.name == config.LogFile) {
+		logFile.SetRotation(config.LogMaxSize, config.LogMaxAge, config.LogMaxBackups)
+		return nil
+	}
+	file, err := OpenRotatingFile(config.LogFile, config.LogMaxSize, config.LogMaxAge, config.LogMaxBackups)
+	if err != nil {
+		return fmt.Errorf("log.file: %s", err)
+	}
+	switchOutput(file)
+	logFile = file
+	return nil
+}
+
+func switchOutput(next io.Writer) {
+	log.SetOutput(next)
+	if (logFile != nil) && (next != logFile) {
+		logFile.Close()
+		logFile = nil
+	}
+}
+
+func newEntry(level log.Level) *log.Entry {
+	prefix, module := findCaller()
+	if !IsEnabled(module, level) {
+		return nil
+	}
+	return log.WithFields(log.Fields{"prefix": prefix})
 }
 
 func Fatal(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Fatal(args...)
+	if entry := newEntry(log.FatalLevel); entry != nil {
+		entry.Fatal(args...)
+	}
 }
 
 func Error(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Error(args...)
+	if entry := newEntry(log.ErrorLevel); entry != nil {
+		entry.Error(args...)
+	}
 }
 
 func Warn(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Warn(args...)
+	if entry := newEntry(log.WarnLevel); entry != nil {
+		entry.Warn(args...)
+	}
 }
 
 func Info(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Info(args...)
+	if entry := newEntry(log.InfoLevel); entry != nil {
+		entry.Info(args...)
+	}
 }
 
 func Debug(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Debug(args...)
+	if entry := newEntry(log.DebugLevel); entry != nil {
+		entry.Debug(args...)
+	}
 }
 
 func Fatalf(format string, args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Fatalf(format, args...)
+	if entry := newEntry(log.FatalLevel); entry != nil {
+		entry.Fatalf(format, args...)
+	}
 }
 
 func Errorf(format string, args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Errorf(format, args...)
+	if entry := newEntry(log.ErrorLevel); entry != nil {
+		entry.Errorf(format, args...)
+	}
 }
 
 func Warnf(format string, args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Warnf(format, args...)
+	if entry := newEntry(log.WarnLevel); entry != nil {
+		entry.Warnf(format, args...)
+	}
 }
 
 func Infof(format string, args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Infof(format, args...)
+	if entry := newEntry(log.InfoLevel); entry != nil {
+		entry.Infof(format, args...)
+	}
 }
 
 func Debugf(format string, args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Debugf(format, args...)
+	if entry := newEntry(log.DebugLevel); entry != nil {
+		entry.Debugf(format, args...)
+	}
 }
 
 func Print(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Info(args...)
+	if entry := newEntry(log.InfoLevel); entry != nil {
+		entry.Info(args...)
+	}
 }
 
 func Println(args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Info(args...)
+	if entry := newEntry(log.InfoLevel); entry != nil {
+		entry.Info(args...)
+	}
 }
 
 func Printf(format string, args ...interface{}) {
-	prefix := findCaller()
-	log.WithFields(log.Fields{"prefix": prefix}).Infof(format, args...)
+	if entry := newEntry(log.InfoLevel); entry != nil {
+		entry.Infof(format, args...)
+	}
 }
 
-func findCaller() string {
+// findCaller returns the prefix of a log and the module, which is the source file name
+// in cperfc or the package name of the others.
+func findCaller() (string, string) {
 	for i := 2; ; i++ {
 		pc, filepath, line, ok := runtime.Caller(i)
 		if !ok {
-			return "Unknown"
+			return "Unknown", "unknown"
 		}
 		parts := strings.Split(filepath, "/")
 		dir := parts[len(parts)-2]
 		file := parts[len(parts)-1]
 		if (dir != "log") || (file != "log.go") {
-			parts = strings.Split(runtime.FuncForPC(pc).Name(), ".")
-			return fmt.Sprintf("%s(%s:%d)", file, parts[len(parts) - 1], int(line))
+			name := runtime.FuncForPC(pc).Name()
+			parts = strings.Split(name, ".")
+			module := strings.TrimSuffix(file, ".go")
+			if pkg := packageOf(name); pkg != "cperfc" {
+				module = path.Base(pkg)
+			}
+			return fmt.Sprintf("%s(%s:%d)", file, parts[len(parts) - 1], int(line)), module
 		}
 	}
-	return "Unknown"
+	return "Unknown", "unknown"
+}
+
+func packageOf(function string) string {
+	slash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[slash + 1:], ".")
+	if dot < 0 {
+		return function
+	}
+	return function[:slash + 1 + dot]
 }
@@ -0,0 +1,150 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedTimeFormat = "20060102-150405.000000000"
+
+// RotatingFile is a log file which is renamed to '<name>.<time>' when it is too large or too old.
+type RotatingFile struct {
+	name			string
+	lock			sync.Mutex
+	file			*os.File
+	size			int64
+	opened			time.Time
+	maxSize			int64			// bytes, 0 not to rotate by size
+	maxAge			time.Duration	// 0 not to rotate by age
+	maxBackups		int				// 0 to keep all
+	closed			bool
+}
+
+var logFile *RotatingFile
+
+func init() {
+}
+
+func OpenRotatingFile(name string, maxSize int, maxAge int, maxBackups int) (*RotatingFile, error) {
+	self := &RotatingFile{name: name}
+	self.SetRotation(maxSize, maxAge, maxBackups)
+	if err := self.open(); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// SetRotation sets the size in MB, the age in hours and the number of the rotated files.
+func (self *RotatingFile)SetRotation(maxSize int, maxAge int, maxBackups int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.maxSize = int64(maxSize) * 1024 * 1024
+	self.maxAge = time.Duration(maxAge) * time.Hour
+	self.maxBackups = maxBackups
+}
+
+func (self *RotatingFile)open() error {
+	file, err := os.OpenFile(self.name, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	self.file = file
+	self.size = info.Size()
+	self.opened = info.ModTime()
+	if self.size == 0 {
+		self.opened = time.Now()
+	}
+	return nil
+}
+
+func (self *RotatingFile)Write(b []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return 0, os.ErrClosed
+	}
+	if self.file != nil {
+		tooLarge := (self.maxSize > 0) && (self.size > 0) && (self.size + int64(len(b)) > self.maxSize)
+		tooOld := (self.maxAge > 0) && (self.size > 0) && (time.Since(self.opened) > self.maxAge)
+		if tooLarge || tooOld {
+			if err := self.rotate(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %s\n", self.name, err)
+			}
+		}
+	}
+	// the file is reopened on every write after a failed rotation, and stderr keeps the logs meanwhile
+	if self.file == nil {
+		if err := self.open(); err != nil {
+			return os.Stderr.Write(b)
+		}
+	}
+	n, err := self.file.Write(b)
+	self.size += int64(n)
+	return n, err
+}
+
+// rotatedName never returns an existing backup, so that a rotation does not overwrite the previous one.
+func (self *RotatingFile)rotatedName(now time.Time) string {
+	for {
+		rotated := self.name + "." + now.Format(rotatedTimeFormat)
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			return rotated
+		}
+		now = now.Add(time.Nanosecond)
+	}
+}
+
+func (self *RotatingFile)rotate() error {
+	self.file.Close()
+	renameErr := os.Rename(self.name, self.rotatedName(time.Now()))
+	if err := self.open(); err != nil {
+		self.file = nil
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	self.removeBackups()
+	return nil
+}
+
+func (self *RotatingFile)removeBackups() {
+	if self.maxBackups == 0 {
+		return
+	}
+	backups, _ := filepath.Glob(self.name + ".*")
+	var rotated []string
+	for _, backup := range backups {
+		suffix := strings.TrimPrefix(backup, self.name + ".")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			rotated = append(rotated, backup)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > self.maxBackups {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+func (self *RotatingFile)Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.closed = true
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
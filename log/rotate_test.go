@@ -0,0 +1,77 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateInSameSecond(t *testing.T) {
+	name := path.Join(t.TempDir(), "cperfc.log")
+	file, err := OpenRotatingFile(name, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.maxSize = 4
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, _ := filepath.Glob(name + ".*")
+	if len(backups) != 3 {
+		t.Fatalf("expected 3 backups, got %v", backups)
+	}
+	var content string
+	for _, backup := range backups {
+		b, _ := ioutil.ReadFile(backup)
+		content += string(b)
+	}
+	if content != "one\ntwo\nthree\n" {
+		t.Errorf("expected the backups in order, got %q", content)
+	}
+
+	file.maxBackups = 2
+	file.Write([]byte("five\n"))
+	if backups, _ := filepath.Glob(name + ".*"); len(backups) != 2 {
+		t.Errorf("expected 2 backups, got %v", backups)
+	}
+}
+
+func TestRotateReopenFailed(t *testing.T) {
+	dir := t.TempDir()
+	name := path.Join(dir, "cperfc.log")
+	file, err := OpenRotatingFile(name, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.maxSize = 4
+	file.Write([]byte("one\n"))
+	// the directory of the log file disappears
+	file.name = path.Join(dir, "none", "cperfc.log")
+	if _, err := file.Write([]byte("two\n")); err != nil {
+		t.Errorf("expected the line to be written to stderr, got %s", err)
+	}
+	if file.file != nil {
+		t.Error("expected the closed file to be forgotten")
+	}
+	// and comes back
+	if err := os.Mkdir(path.Join(dir, "none"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("three\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(file.name); string(b) != "three\n" {
+		t.Errorf("expected the file to be reopened on the next write, got %q", b)
+	}
+	if err := file.Close(); err != nil {
+		t.Errorf("expected no error closing, got %s", err)
+	}
+	if _, err := file.Write([]byte("four\n")); err != os.ErrClosed {
+		t.Errorf("expected a closed file not to be reopened, got %v", err)
+	}
+}
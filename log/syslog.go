@@ -0,0 +1,96 @@
+package log
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// syslogHook sends every log passing the module levels to syslog, journald reads the local one.
+type syslogHook struct {
+	lock			sync.Mutex
+	address			string
+	writer			*syslog.Writer
+}
+
+var syslogOutput = &syslogHook{}
+var syslogOnce sync.Once
+
+func init() {
+}
+
+func setSyslog(address string) error {
+	syslogOutput.lock.Lock()
+	defer syslogOutput.lock.Unlock()
+	if address == syslogOutput.address {
+		return nil
+	}
+	var writer *syslog.Writer
+	var err error
+	switch {
+	case len(address) == 0:
+	case address == "local":
+		writer, err = syslog.New(syslog.LOG_DAEMON | syslog.LOG_INFO, "cperfc")
+	default:
+		u, parseErr := url.Parse(address)
+		if parseErr != nil {
+			return fmt.Errorf("log.syslog: %s", parseErr)
+		}
+		writer, err = syslog.Dial(u.Scheme, u.Host, syslog.LOG_DAEMON | syslog.LOG_INFO, "cperfc")
+	}
+	if err != nil {
+		return fmt.Errorf("log.syslog: %s", err)
+	}
+	if syslogOutput.writer != nil {
+		syslogOutput.writer.Close()
+	}
+	syslogOutput.address = address
+	syslogOutput.writer = writer
+	syslogOnce.Do(func() {
+		log.AddHook(syslogOutput)
+	})
+	return nil
+}
+
+func (self *syslogHook)Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel, log.WarnLevel, log.InfoLevel, log.DebugLevel}
+}
+
+func (self *syslogHook)Fire(entry *log.Entry) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.writer == nil {
+		return nil
+	}
+	message := entry.Message
+	if prefix, ok := entry.Data["prefix"]; ok {
+		message = fmt.Sprintf("%s: %s", prefix, message)
+	}
+	var keys []string
+	for key := range entry.Data {
+		if key != "prefix" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		message += fmt.Sprintf(" %s=%v", key, entry.Data[key])
+	}
+	message = strings.Replace(message, "\n", " ", -1)
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel:
+		return self.writer.Crit(message)
+	case log.ErrorLevel:
+		return self.writer.Err(message)
+	case log.WarnLevel:
+		return self.writer.Warning(message)
+	case log.InfoLevel:
+		return self.writer.Info(message)
+	}
+	return self.writer.Debug(message)
+}
@@ -3,13 +3,12 @@ package cperfc
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	cAdvisorClient "github.com/google/cadvisor/client"
 	cAdvisorInfo "github.com/google/cadvisor/info/v1"
 
 	"cperfc/config"
@@ -19,62 +18,147 @@ import (
 
 const numberOfRequest = 64
 
-var cAdvisor *cAdvisorClient.Client
-var loopController chan bool
+var loopLock sync.Mutex
+var loopController chan bool	// closed to stop the running main loop, nil if it is not running
+var loopPaused bool				// paused by the API, kept until it is resumed, also while cAdvisor is awaited
+var loopInterval = make(chan bool, 1)
+var loopGroup sync.WaitGroup
 
 func init() {
 }
 
-func StartMonitoring() {
+// StartMonitoring starts the main loop once cAdvisor is reachable, without waiting for it.
+func StartMonitoring() error {
 	var err error
 
 	log.Info("Initializing container monitoring tools.")
-	cAdvisor, err = cAdvisorClient.NewClient(config.CAdvisorAddr)
-	if err != nil {
-		log.Error(err)
-		log.Info("Terminates")
-		finish(config.EXITCADVISOR)
+	if _, err = GetCAdvisor().getClient(); err != nil {
+		return newDaemonError(config.EXITCADVISOR, "cAdvisor[%s]: %s", config.CAdvisorAddr, err)
 	}
-	_, err = cAdvisor.MachineInfo()
-	if err != nil {
-		log.Errorf("Failed to connect cAdvisor[%s].", config.CAdvisorAddr)
-		log.Info("Terminates")
-		finish(config.EXITCADVISOR)
+	if len(config.TraceFile) > 0 {
+		traceRecorder, err = OpenTraceRecorder(config.TraceFile)
+		if err != nil {
+			log.Errorf("Failed to open trace file[%s]: %s", config.TraceFile, err)
+		} else {
+			log.Infof("Recording samples to %s.", config.TraceFile)
+		}
 	}
-	loopController = make(chan bool)
-	log.Infof("cAdvisor[%s] is running.", config.CAdvisorAddr)
-	log.Info("Starting monitoring.")
-	StartMainLoop()
+	startupStop = make(chan bool)
+	startupGroup.Add(1)
+	go waitCAdvisor(startupStop)
+	return nil
+}
+
+// StopMonitoring stops the main loop, waits for the running iteration and closes the trace.
+func StopMonitoring() error {
+	if startupStop != nil {
+		close(startupStop)
+		startupStop = nil
+	}
+	startupGroup.Wait()
+	loopLock.Lock()
+	stopMainLoop()
+	loopLock.Unlock()
+	loopGroup.Wait()
+	if traceRecorder != nil {
+		recorder := traceRecorder
+		traceRecorder = nil
+		return recorder.Close()
+	}
+	return nil
 }
 
+// StartMainLoop resumes the main loop, even before cAdvisor is reachable.
 func StartMainLoop() {
-	close(loopController)
+	loopLock.Lock()
+	defer loopLock.Unlock()
+	loopPaused = false
+	startMainLoop()
+}
+
+// StopMainLoop pauses the main loop, which is not started when cAdvisor becomes reachable.
+func StopMainLoop() {
+	loopLock.Lock()
+	defer loopLock.Unlock()
+	loopPaused = true
+	stopMainLoop()
+}
+
+// resumeMainLoop starts the main loop unless it is paused, false if it is paused.
+func resumeMainLoop() bool {
+	loopLock.Lock()
+	defer loopLock.Unlock()
+	if loopPaused {
+		return false
+	}
+	startMainLoop()
+	return true
+}
+
+func startMainLoop() {
+	if loopController != nil {
+		return
+	}
 	loopController = make(chan bool)
-	go mainLooper()
+	loopGroup.Add(1)
+	setLoopRunning(true)
+	go mainLooper(loopController)
 }
 
-func mainLooper() {
-	ticker := time.NewTicker(time.Duration(config.MainLoopInterval) * time.Second)
+func stopMainLoop() {
+	if loopController == nil {
+		return
+	}
+	close(loopController)
+	loopController = nil
+	setLoopRunning(false)
+}
+
+func mainLooper(stop chan bool) {
+	defer loopGroup.Done()
+	config.RLock()
+	interval := time.Duration(config.MainLoopInterval) * time.Second
+	config.RUnlock()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	loopSkipCount := 0
 	for {
 		select {
 		case <- ticker.C:
-			loopSkipCount = monitoring(loopSkipCount)
-		case <- loopController:
+			start := time.Now()
+			skipped := !monitoring()
+			duration := time.Since(start)
+			missed := int(duration / interval)
+			if missed > 0 {
+				log.Warnf("Monitoring took %s, longer than the interval %s, %d ticks are missed.", duration, interval, missed)
+			}
+			recordLoopIteration(start, duration, skipped, missed)
+		case <- loopInterval:
+			ticker.Stop()
+			config.RLock()
+			seconds := config.MainLoopInterval
+			config.RUnlock()
+			interval = time.Duration(seconds) * time.Second
+			ticker = time.NewTicker(interval)
+			log.Infof("Monitoring interval is %d seconds.", seconds)
+		case <- stop:
 			return
 		}
 	}
 }
 
-func StopMainLoop() {
-	close(loopController)
-	loopController = make(chan bool)
+func ResetMainLoopInterval() {
+	select {
+	case loopInterval <- true:
+	default:
+	}
 }
 
-func monitoring(loopSkipCount int) int {
+// monitoring is an iteration of the main loop, false if it is skipped for cAdvisor.
+func monitoring() bool {
 	var outBuffer bytes.Buffer
 
+	config.RLock()
+	defer config.RUnlock()
 	defer func() {
 		if len(outBuffer.String()) > 0 {
 			log.Debug(strings.Replace(outBuffer.String(), "\n", "\n\t", -1))
@@ -83,75 +167,204 @@ func monitoring(loopSkipCount int) int {
 	manager := GetContainerManager()
 	allRegisteredContainers := manager.GetAllContainers()
 	outBuffer.WriteString(fmt.Sprintf("Container(%d) monitoring.", len(allRegisteredContainers)))
-	if loopSkipCount > 0 {
-		log.Warnf("cooldown for cAdvisor(%d)", loopSkipCount)
-		return loopSkipCount - 1
-	}
-	machine, err := cAdvisor.MachineInfo()
+	machine, err := GetCAdvisor().MachineInfo()
 	if err != nil {
-		log.Error(fmt.Sprint(err))
-		return config.LOOPSKIPCOUNT
+		if err == ErrCircuitOpen {
+			outBuffer.WriteString(fmt.Sprintf(" Skipped, cAdvisor will be retried at %s.", GetCAdvisor().Status().RetryAt.Format(time.RFC3339)))
+		}
+		return false
 	}
-	machineCores := machine.NumCores
+	machineCores = machine.NumCores
 	freq := machine.CpuFrequency
 
 	if len(allRegisteredContainers) == 0 {
 		outBuffer.Reset()
 	}
-	request := cAdvisorInfo.ContainerInfoRequest{NumStats: numberOfRequest}
+	now := clock()
+	var targets []*Container
 	for _, registeredContainer := range allRegisteredContainers {
-		container, err := cAdvisor.ContainerInfo(path.Join("/", path.Join(registeredContainer.Type, registeredContainer.Id)), &request)
-		if err != nil {
-			outBuffer.WriteString(fmt.Sprintln(err))
-			return config.LOOPSKIPCOUNT
+		registeredContainer.lock.Lock()
+		if !now.Before(registeredContainer.sampleRetryAt) {
+			targets = append(targets, registeredContainer)
+		}
+		registeredContainer.lock.Unlock()
+	}
+	var failed []string
+	for _, fetched := range fetchContainers(targets) {
+		registeredContainer, container := fetched.container, fetched.info
+		if fetched.err == ErrCircuitOpen {
+			continue
 		}
-		ratio, duration, timestamp, err := CalcCPUUsage(container, false)
+		if fetched.err != nil {
+			failed = append(failed, registeredContainer.Id)
+			sampleFailed(registeredContainer, fetched.err, now)
+			continue
+		}
+		registeredContainer.lock.Lock()
+		registeredContainer.sampleFailures = 0
+		observeContainer(registeredContainer, container)
+		container.Stats = mergeStats(registeredContainer, container.Stats)
+		registeredContainer.CgroupCurrent.CPUSet.CPUS = container.Spec.Cpu.Mask
+		registeredContainer.CgroupCurrent.CPU.Shares = strconv.FormatUint(container.Spec.Cpu.Limit, 10)
+		for _, sample := range collectSamples(registeredContainer, container) {
+			collected := sample
+			GetEventBus().Publish(Event{Type: EventSampleCollected, Timestamp: sample.Timestamp, Id: registeredContainer.Id, Container: registeredContainer, Sample: &collected})
+		}
+		long, err := CalcCPUUsage(container.Stats, &registeredContainer.masks, container.Spec.Cpu.Mask, false)
 		if err == nil {
-			registeredContainer.Timestamp = timestamp
-			registeredContainer.CPUUsageLong = ratio
-			actualCores := len(cgroups.DecodeListFormat(container.Spec.Cpu.Mask))
+			registeredContainer.Timestamp = long.Timestamp
+			registeredContainer.CPUUsageLong = long.Percent
+			registeredContainer.CoresUsedLong = long.Cores
 			if container.Namespace == config.DockerName {
 				outBuffer.WriteString(fmt.Sprintf("\n%s(%s)", container.Name, container.Spec.Image))
 			} else {
 				outBuffer.WriteString(fmt.Sprintf("\n%s", container.Name))
 			}
-			outBuffer.WriteString(fmt.Sprintf("\n\t%.4f%% of %d(%s)/%d(0-%d) cores at %.2fGHz for %d seconds", ratio, actualCores, container.Spec.Cpu.Mask, machineCores, machineCores - 1, float64(freq) / 1000000, duration))
-			ratio, duration, _, _ = CalcCPUUsage(container, true)
-			registeredContainer.CPUUsageShort = ratio
-			outBuffer.WriteString(fmt.Sprintf("\n\t%.4f%% of %d(%s)/%d(0-%d) cores at %.2fGHz for %d seconds", ratio, actualCores, container.Spec.Cpu.Mask, machineCores, machineCores - 1, float64(freq) / 1000000, duration))
-			registeredContainer.CPUUsageLong = ratio
+			outBuffer.WriteString(fmt.Sprintf("\n\t%.4f%% of %.2f(%s)/%d(0-%d) cores, %.2f cores at %.2fGHz for %.0f seconds", long.Percent, long.Allotted, container.Spec.Cpu.Mask, machineCores, machineCores - 1, long.Cores, float64(freq) / 1000000, long.Duration))
+			if long.Resets > 0 {
+				outBuffer.WriteString(fmt.Sprintf(", %d counter resets skipped", long.Resets))
+			}
 		}
+		if short, err := CalcCPUUsage(container.Stats, &registeredContainer.masks, container.Spec.Cpu.Mask, true); err == nil {
+			registeredContainer.CPUUsageShort = short.Percent
+			registeredContainer.CoresUsedShort = short.Cores
+			outBuffer.WriteString(fmt.Sprintf("\n\t%.4f%% of %.2f(%s)/%d(0-%d) cores, %.2f cores at %.2fGHz for %.0f seconds", short.Percent, short.Allotted, container.Spec.Cpu.Mask, machineCores, machineCores - 1, short.Cores, float64(freq) / 1000000, short.Duration))
+		}
+		scaleContainer(registeredContainer)
+		registeredContainer.lock.Unlock()
+	}
+	if (len(failed) > 1) && (len(failed) == len(targets)) {
+		GetCAdvisor().Record(fmt.Errorf("all %d containers failed to sample", len(failed)))
 	}
-	return 0
+	return true
 }
 
-func CalcCPUUsage(container *cAdvisorInfo.ContainerInfo, justNow bool) (ratio float64, duration int, timestamp time.Time, err error) {
-	if len(container.Stats) >= 2 {
-		var prevEvents *cAdvisorInfo.ContainerStats
+type fetchedContainer struct {
+	container		*Container
+	info			*cAdvisorInfo.ContainerInfo
+	err				error
+}
 
-		if justNow {
-			prevEvents = container.Stats[len(container.Stats) - 2]
-		} else {
-			prevEvents = container.Stats[0]
+// fetchContainers gets the containers from cAdvisor by 'cadvisor_client.workers' in parallel, in the given order.
+func fetchContainers(containers []*Container) []fetchedContainer {
+	var group sync.WaitGroup
+
+	fetched := make([]fetchedContainer, len(containers))
+	indexes := make(chan int)
+	workers := config.CAdvisorWorkers
+	if workers > len(containers) {
+		workers = len(containers)
+	}
+	for i := 0; i < workers; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for index := range indexes {
+				container := containers[index]
+				info, err := GetCAdvisor().ContainerInfo(containerInfoName(container), statsRequest(container))
+				fetched[index] = fetchedContainer{container: container, info: info, err: err}
+			}
+		}()
+	}
+	for index := range containers {
+		indexes <- index
+	}
+	close(indexes)
+	group.Wait()
+	return fetched
+}
+
+// statsRequest asks only the stats newer than the last one seen of the container.
+func statsRequest(container *Container) *cAdvisorInfo.ContainerInfoRequest {
+	request := cAdvisorInfo.ContainerInfoRequest{NumStats: numberOfRequest}
+	container.lock.Lock()
+	defer container.lock.Unlock()
+	if last := len(container.stats) - 1; last >= 0 {
+		request.Start = container.stats[last].Timestamp.Add(time.Nanosecond)
+	}
+	return &request
+}
+
+// mergeStats appends the new stats to the ones seen of the container and keeps the last 'numberOfRequest'.
+func mergeStats(container *Container, stats []*cAdvisorInfo.ContainerStats) []*cAdvisorInfo.ContainerStats {
+	merged := container.stats
+	for _, stat := range stats {
+		if last := len(merged) - 1; (last >= 0) && !stat.Timestamp.After(merged[last].Timestamp) {
+			continue
 		}
-		currEvents := container.Stats[len(container.Stats) - 1]
-		timeDelta := currEvents.Timestamp.Sub(prevEvents.Timestamp).Nanoseconds()
-		usageDelta := currEvents.Cpu.Usage.Total - prevEvents.Cpu.Usage.Total
-		actualCores := len(cgroups.DecodeListFormat(container.Spec.Cpu.Mask))
-		usageRatio := float64(usageDelta) / (float64(timeDelta) * float64(actualCores))
-		return usageRatio * 100, int(timeDelta / 1000 / 1000 / 1000), currEvents.Timestamp, nil
+		merged = append(merged, stat)
 	}
-	return 0, 0, time.Now(), errors.New("Not enough 'Stats'")
+	if len(merged) > numberOfRequest {
+		merged = append([]*cAdvisorInfo.ContainerStats(nil), merged[len(merged) - numberOfRequest:]...)
+	}
+	container.stats = merged
+	return merged
+}
+
+// observeContainer forgets the stats of a restarted container, seeds the cpuset timeline with the first
+// mask seen and records a cpuset changed out of cperfc.
+func observeContainer(registeredContainer *Container, container *cAdvisorInfo.ContainerInfo) {
+	created := container.Spec.CreationTime
+	if !registeredContainer.createdAt.IsZero() && !created.Equal(registeredContainer.createdAt) {
+		log.Infof("%s: restarted at %s, usage is accounted from then.", registeredContainer.Id, created.Format(time.RFC3339))
+		registeredContainer.stats = nil
+	}
+	registeredContainer.createdAt = created
+	mask := container.Spec.Cpu.Mask
+	if registeredContainer.masks.Len() == 0 {
+		registeredContainer.masks.Set(time.Time{}, mask)
+		return
+	}
+	if registeredContainer.masks.At(clock(), mask) == mask {
+		return
+	}
+	changed := clock()
+	if last := len(container.Stats) - 1; last >= 0 {
+		changed = container.Stats[last].Timestamp
+	}
+	registeredContainer.masks.Set(changed, mask)
 }
 
-func GetContainerInfo(container Container) (cAdvisorInfo.ContainerInfo, error) {
+func collectSamples(registeredContainer *Container, container *cAdvisorInfo.ContainerInfo) []Sample {
+	var samples []Sample
+
+	history := registeredContainer.History
+	if history == nil {
+		return samples
+	}
+	last, exist := history.Last()
+	intervals, _ := CPUIntervals(container.Stats, &registeredContainer.masks, container.Spec.Cpu.Mask)
+	for _, interval := range intervals {
+		if exist && !interval.To.After(last.Timestamp) {
+			continue
+		}
+		currEvents := interval.end
+		sample := Sample{
+			Timestamp: interval.To,
+			CPUUsage: interval.Percent(),
+			Cores: len(cgroups.DecodeListFormat(interval.CPUS)),
+			CoresUsed: interval.Cores(),
+			CPUS: interval.CPUS,
+			Shares: container.Spec.Cpu.Limit,
+			ThrottledPeriods: currEvents.Cpu.CFS.ThrottledPeriods,
+			ThrottledTime: currEvents.Cpu.CFS.ThrottledTime,
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func GetContainerInfo(container *Container) (cAdvisorInfo.ContainerInfo, error) {
 	var info cAdvisorInfo.ContainerInfo
 
 	request := cAdvisorInfo.ContainerInfoRequest{NumStats: 1}
-	org, err := cAdvisor.ContainerInfo(path.Join("/", path.Join(container.Type, container.Id)), &request)
+	org, err := GetCAdvisor().ContainerInfo(containerInfoName(container), &request)
+	if err != nil {
+		return info, err
+	}
 	info = *org
 	info.Stats = nil
-	return info, err
+	return info, nil
 }
 
 func JSONStructureToString(v interface{}) string {
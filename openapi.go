@@ -0,0 +1,438 @@
+package cperfc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"cperfc/log"
+)
+
+type openAPIOperation struct {
+	Method			string
+	Path			string
+	Summary			string
+	Query			[]string
+	Request			interface{}
+	Responses		map[int]interface{}		// nil for no content
+	Deprecated		bool
+	Role			string					// minimum role, viewer if empty
+}
+
+type openAPIResponseRecorder struct {
+	http.ResponseWriter
+	status			int
+	body			bytes.Buffer
+}
+
+const openAPIVersion = "3.0.3"
+const apiVersion = "1.0.0"
+
+var openAPIPathParameter = regexp.MustCompile("{([^}]+)}")
+var openAPITimeType = reflect.TypeOf(time.Time{})
+
+var openAPICurrentOperations = []openAPIOperation{
+	{Method: "GET", Path: "/healthz", Summary: "Liveness of the process", Responses: map[int]interface{}{200: SimpleResult{}}, Role: "public"},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness = cAdvisor reachable, cgroups initialized and state loaded, the loop may be paused", Responses: map[int]interface{}{200: ReadyStatus{}, 503: ReadyStatus{}}, Role: "public"},
+	{Method: "GET", Path: "/debug/status", Summary: "Loop timings, errors, goroutines and build info", Responses: map[int]interface{}{200: DebugStatus{}}},
+	{Method: "GET", Path: "/debug/pprof/", Summary: "pprof profiles if 'debug.pprof' is enabled", Responses: map[int]interface{}{200: nil, 404: nil}, Role: "admin"},
+	{Method: "GET", Path: "/openapi.json", Summary: "OpenAPI document", Responses: map[int]interface{}{200: map[string]interface{}{}}},
+	{Method: "GET", Path: "/api/stream", Summary: "Server-Sent Events of samples and actions", Query: []string{"cid", "type"}, Responses: map[int]interface{}{200: nil}},
+	{Method: "GET", Path: "/v1/containers", Summary: "List registered containers", Query: []string{"type", "label", "image", "min_usage", "max_usage", "sort", "order", "offset", "limit"}, Responses: map[int]interface{}{200: ContainerList{}, 400: nil}},
+	{Method: "GET", Path: "/v1/containers/{cid}", Summary: "Status of a registered container", Responses: map[int]interface{}{200: Container{}, 404: nil}},
+	{Method: "POST", Path: "/v1/containers/{cid}", Summary: "Register a container", Query: []string{"policy"}, Request: PolicyRequest{}, Responses: map[int]interface{}{201: Container{}, 400: nil, 404: nil, 409: nil, 422: nil}, Role: "operator"},
+	{Method: "DELETE", Path: "/v1/containers/{cid}", Summary: "Unregister a container", Responses: map[int]interface{}{204: nil, 404: nil}, Role: "operator"},
+	{Method: "GET", Path: "/v1/containers/{cid}/metrics", Summary: "Sample history of a container", Query: []string{"from", "to", "step"}, Responses: map[int]interface{}{200: []Sample{}, 400: nil, 404: nil}},
+	{Method: "POST", Path: "/v1/containers/{cid}/reports", Summary: "Report an application SLO metric", Request: SLOReport{}, Responses: map[int]interface{}{202: SLOReport{}, 400: nil, 404: nil}, Role: "operator"},
+	{Method: "PUT", Path: "/v1/containers/{cid}/cpu", Summary: "Set cpu request", Request: CgroupCPU{}, Responses: map[int]interface{}{200: CgroupInfo{}, 400: nil, 404: nil, 422: nil, 500: nil}, Role: "operator"},
+	{Method: "DELETE", Path: "/v1/containers/{cid}/cpu", Summary: "Reset cpu cgroup", Responses: map[int]interface{}{204: nil, 404: nil, 500: nil}, Role: "operator"},
+	{Method: "PUT", Path: "/v1/containers/{cid}/cpuset", Summary: "Set cpuset request", Request: CgroupCPUSet{}, Responses: map[int]interface{}{200: CgroupInfo{}, 400: nil, 404: nil, 422: nil, 500: nil}, Role: "operator"},
+	{Method: "DELETE", Path: "/v1/containers/{cid}/cpuset", Summary: "Reset cpuset cgroup", Responses: map[int]interface{}{204: nil, 404: nil, 500: nil}, Role: "operator"},
+	{Method: "PUT", Path: "/v1/containers/{cid}/policy", Summary: "Set scaling policy", Request: PolicyRequest{}, Responses: map[int]interface{}{200: CgroupInfo{}, 400: nil, 404: nil, 422: nil}, Role: "operator"},
+	{Method: "PUT", Path: "/v1/containers/{cid}/slo", Summary: "Set SLO request", Request: CgroupSLO{}, Responses: map[int]interface{}{200: CgroupInfo{}, 400: nil, 404: nil, 422: nil}, Role: "operator"},
+	{Method: "PUT", Path: "/v1/containers/{cid}/dryrun", Summary: "Set dry-run mode", Request: DryRunRequest{}, Responses: map[int]interface{}{200: CgroupInfo{}, 400: nil, 404: nil}, Role: "operator"},
+	{Method: "GET", Path: "/v1/host/containers", Summary: "Containers on the host", Query: []string{"managed"}, Responses: map[int]interface{}{200: []HostContainer{}}},
+	{Method: "GET", Path: "/v1/recommendations", Summary: "Dry-run recommendations", Query: []string{"cid"}, Responses: map[int]interface{}{200: []Recommendation{}}},
+	{Method: "GET", Path: "/v1/events", Summary: "Event bus metrics and subscribers", Responses: map[int]interface{}{200: EventBusStatus{}}},
+	{Method: "GET", Path: "/v1/audit", Summary: "Recent audit entries of cgroup writes and API mutations", Query: []string{"cid", "actor", "action", "since", "limit"}, Responses: map[int]interface{}{200: AuditList{}, 400: nil}, Role: "admin"},
+	{Method: "GET", Path: "/v1/log/levels", Summary: "Log levels of modules", Responses: map[int]interface{}{200: log.Levels{}}},
+	{Method: "PUT", Path: "/v1/log/levels", Summary: "Change log levels until the next reload", Request: LogLevelRequest{}, Responses: map[int]interface{}{200: log.Levels{}, 400: nil, 422: nil}, Role: "admin"},
+	{Method: "GET", Path: "/v1/processes/{pid}/container", Summary: "Container of a process", Responses: map[int]interface{}{200: Container{}, 400: nil, 404: nil}},
+	{Method: "POST", Path: "/v1/control/{controlMsg}", Summary: "Control the daemon = {pause, resume, dryrun, live, reload, exit}", Responses: map[int]interface{}{200: SimpleResult{}, 202: SimpleResult{}, 400: nil, 422: nil}, Role: "admin"},
+}
+
+// openAPILegacyOperations are deprecated and documented for every method in 'legacyMethods'.
+var openAPILegacyOperations = []openAPIOperation{
+	{Path: "/", Summary: "Index", Responses: map[int]interface{}{200: nil}},
+	{Path: "/control/{controlMsg}", Summary: "Control the daemon", Responses: map[int]interface{}{200: nil, 422: nil}, Role: "admin"},
+	{Path: "/api/process/getcontainer/{pid}", Summary: "Container of a process", Responses: map[int]interface{}{200: Container{}, 400: Container{}}},
+	{Path: "/api/containers", Summary: "List registered containers", Query: []string{"type", "label", "image", "min_usage", "max_usage", "sort", "order", "offset", "limit"}, Responses: map[int]interface{}{200: ContainerList{}, 400: ContainerList{}}},
+	{Path: "/api/host/containers", Summary: "Containers on the host", Query: []string{"managed"}, Responses: map[int]interface{}{200: []HostContainer{}}},
+	{Path: "/api/container/register/{cid}", Summary: "Register a container", Query: []string{"policy"}, Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/unregister/{cid}", Summary: "Unregister a container", Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/isregistered/{cid}", Summary: "Check registration", Responses: map[int]interface{}{200: SimpleResult{}}},
+	{Path: "/api/container/status/{cid}", Summary: "Status of a container", Responses: map[int]interface{}{200: Container{}}},
+	{Path: "/api/container/metrics/{cid}", Summary: "Sample history of a container", Query: []string{"from", "to", "step"}, Responses: map[int]interface{}{200: []Sample{}, 400: []Sample{}}},
+	{Path: "/api/container/set/cpu/{cid}", Summary: "Set cpu request", Request: CgroupCPU{}, Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/set/cpuset/{cid}", Summary: "Set cpuset request", Request: CgroupCPUSet{}, Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/set/policy/{cid}/{policy}", Summary: "Set scaling policy", Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/set/slo/{cid}", Summary: "Set SLO request", Request: CgroupSLO{}, Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/report/{cid}", Summary: "Report an application SLO metric", Query: []string{"metric", "value"}, Request: SLOReport{}, Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/container/set/dryrun/{cid}/{mode}", Summary: "Set dry-run mode", Responses: map[int]interface{}{200: SimpleResult{}}, Role: "operator"},
+	{Path: "/api/recommendations", Summary: "Dry-run recommendations", Query: []string{"cid"}, Responses: map[int]interface{}{200: []Recommendation{}}},
+	{Path: "/api/container/reset/cpu/{cid}", Summary: "Reset cgroups", Responses: map[int]interface{}{200: RegisteredResult(false)}, Role: "operator"},
+	{Path: "/api/container/reset/cpuset/{cid}", Summary: "Reset cpuset cgroup", Responses: map[int]interface{}{200: nil}, Role: "operator"},
+}
+
+// legacyMethods are accepted by the deprecated routes, which took any method before /v1.
+var legacyMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+var openAPIOperations = append(openAPICurrentOperations, expandLegacyOperations(openAPILegacyOperations)...)
+
+var openAPIDocument map[string]interface{}
+var openAPIDocumentOnce sync.Once
+
+func init() {
+}
+
+func expandLegacyOperations(legacy []openAPIOperation) []openAPIOperation {
+	var operations []openAPIOperation
+
+	for _, operation := range legacy {
+		for _, method := range legacyMethods {
+			expanded := operation
+			expanded.Method = method
+			expanded.Deprecated = true
+			operations = append(operations, expanded)
+		}
+	}
+	return operations
+}
+
+func GetOpenAPIDocument() map[string]interface{} {
+	openAPIDocumentOnce.Do(buildOpenAPIDocument)
+	return openAPIDocument
+}
+
+func buildOpenAPIDocument() {
+	components := make(map[string]interface{})
+	paths := make(map[string]interface{})
+	errorSchema := openAPISchema(reflect.TypeOf(APIErrorEnvelope{}), components)
+	for _, operation := range openAPIOperations {
+		item, exist := paths[operation.Path].(map[string]interface{})
+		if !exist {
+			item = make(map[string]interface{})
+			paths[operation.Path] = item
+		}
+		var parameters []interface{}
+		for _, match := range openAPIPathParameter.FindAllStringSubmatch(operation.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}})
+		}
+		for _, name := range operation.Query {
+			parameters = append(parameters, map[string]interface{}{"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"}})
+		}
+		responses := make(map[string]interface{})
+		for status, body := range operation.Responses {
+			response := map[string]interface{}{"description": http.StatusText(status)}
+			if body != nil {
+				response["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(body), components)}}
+			} else if (status >= 400) && strings.HasPrefix(operation.Path, "/v1/") {
+				response["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}}
+			}
+			responses[fmt.Sprint(status)] = response
+		}
+		for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+			if _, exist := responses[fmt.Sprint(status)]; !exist && (operation.GetRole() != RolePublic) {
+				responses[fmt.Sprint(status)] = map[string]interface{}{"description": http.StatusText(status), "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}}}
+			}
+		}
+		spec := map[string]interface{}{"summary": operation.Summary, "responses": responses}
+		if len(parameters) > 0 {
+			spec["parameters"] = parameters
+		}
+		if operation.Request != nil {
+			spec["requestBody"] = map[string]interface{}{"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(operation.Request), components)}}}
+		}
+		if operation.Deprecated {
+			spec["deprecated"] = true
+		}
+		spec["x-role"] = operation.GetRole()
+		if operation.GetRole() == RolePublic {
+			spec["security"] = []interface{}{}
+		}
+		item[strings.ToLower(operation.Method)] = spec
+	}
+	openAPIDocument = map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{"title": "cperfc", "version": apiVersion},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+			"securitySchemes": map[string]interface{}{"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"}},
+		},
+		"security": []interface{}{map[string]interface{}{"bearerAuth": []interface{}{}}},
+	}
+}
+
+func (self *openAPIOperation)GetRole() string {
+	if len(self.Role) == 0 {
+		return RoleViewer
+	}
+	return self.Role
+}
+
+func findOpenAPIRole(method string, template string) (string, bool) {
+	for _, operation := range openAPIOperations {
+		if (operation.Path == template) && (operation.Method == method) {
+			return operation.GetRole(), true
+		}
+	}
+	return "", false
+}
+
+func openAPISchemaName(t reflect.Type) string {
+	if strings.HasSuffix(t.PkgPath(), "cperfc") {
+		return t.Name()
+	}
+	parts := strings.Split(t.PkgPath(), "/")
+	return parts[len(parts) - 1] + "." + t.Name()
+}
+
+func openAPISchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	if t == openAPITimeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := make(map[string]interface{})
+		for key, value := range openAPISchema(t.Elem(), components) {
+			schema[key] = value
+		}
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), components), "nullable": true}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), components), "nullable": true}
+	case reflect.Struct:
+		name := openAPISchemaName(t)
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, exist := components[name]; exist {
+			return ref
+		}
+		properties := make(map[string]interface{})
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		components[name] = schema
+		openAPIProperties(t, properties, components)
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func openAPIProperties(t reflect.Type, properties map[string]interface{}, components map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if (tag == "-") || (len(field.PkgPath) > 0 && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && (len(name) == 0) && (field.Type.Kind() == reflect.Struct) {
+			openAPIProperties(field.Type, properties, components)
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		properties[name] = openAPISchema(field.Type, components)
+	}
+}
+
+func restfulOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(GetOpenAPIDocument())
+}
+
+func findOpenAPIOperation(method string, template string) (map[string]interface{}, bool) {
+	paths := GetOpenAPIDocument()["paths"].(map[string]interface{})
+	item, exist := paths[template].(map[string]interface{})
+	if !exist {
+		return nil, false
+	}
+	operation, exist := item[strings.ToLower(method)].(map[string]interface{})
+	return operation, exist
+}
+
+func VerifyOpenAPIRoutes(router *mux.Router) []string {
+	var problems []string
+
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"}
+		}
+		for _, method := range methods {
+			if _, exist := findOpenAPIOperation(method, template); !exist {
+				problems = append(problems, fmt.Sprintf("%s %s is not in the OpenAPI document", method, template))
+			}
+		}
+		return nil
+	})
+	sort.Strings(problems)
+	return problems
+}
+
+func (self *openAPIResponseRecorder)WriteHeader(status int) {
+	self.status = status
+	self.ResponseWriter.WriteHeader(status)
+}
+
+func (self *openAPIResponseRecorder)Write(b []byte) (int, error) {
+	if self.status == 0 {
+		self.status = http.StatusOK
+	}
+	self.body.Write(b)
+	return self.ResponseWriter.Write(b)
+}
+
+func (self *openAPIResponseRecorder)Flush() {
+	if flusher, ok := self.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func openAPIValidator(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var match mux.RouteMatch
+
+		if !router.Match(r, &match) || (match.Route == nil) {
+			router.ServeHTTP(w, r)
+			return
+		}
+		template, _ := match.Route.GetPathTemplate()
+		recorder := &openAPIResponseRecorder{ResponseWriter: w}
+		router.ServeHTTP(recorder, r)
+		for _, problem := range ValidateOpenAPIResponse(r.Method, template, recorder.status, recorder.body.Bytes()) {
+			log.Warnf("OpenAPI contract: %s %s: %s", r.Method, template, problem)
+		}
+	})
+}
+
+func ValidateOpenAPIResponse(method string, template string, status int, body []byte) []string {
+	var value interface{}
+
+	operation, exist := findOpenAPIOperation(method, template)
+	if !exist {
+		return []string{"undocumented operation"}
+	}
+	response, exist := operation["responses"].(map[string]interface{})[fmt.Sprint(status)].(map[string]interface{})
+	if !exist {
+		return []string{fmt.Sprintf("undocumented status %d", status)}
+	}
+	content, exist := response["content"].(map[string]interface{})
+	if !exist {
+		return nil
+	}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{fmt.Sprintf("response is not JSON: %s", err)}
+	}
+	schema := content["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	components := GetOpenAPIDocument()["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	return validateOpenAPISchema("$", value, schema, components)
+}
+
+func validateOpenAPISchema(location string, value interface{}, schema map[string]interface{}, components map[string]interface{}) []string {
+	var problems []string
+
+	if ref, exist := schema["$ref"].(string); exist {
+		return validateOpenAPISchema(location, value, components[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{}), components)
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable {
+			problems = append(problems, fmt.Sprintf("%s: null is not allowed", location))
+		}
+		return problems
+	}
+	if allOf, exist := schema["allOf"].([]interface{}); exist {
+		for _, sub := range allOf {
+			problems = append(problems, validateOpenAPISchema(location, value, sub.(map[string]interface{}), components)...)
+		}
+		return problems
+	}
+	mismatch := func(expected string) []string {
+		return []string{fmt.Sprintf("%s: expected %s, got %T", location, expected, value)}
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch("object")
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for key, item := range object {
+			if property, exist := properties[key].(map[string]interface{}); exist {
+				problems = append(problems, validateOpenAPISchema(location + "." + key, item, property, components)...)
+			} else if additional != nil {
+				problems = append(problems, validateOpenAPISchema(location + "." + key, item, additional, components)...)
+			} else if properties != nil {
+				problems = append(problems, fmt.Sprintf("%s.%s: undocumented property", location, key))
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			problems = append(problems, validateOpenAPISchema(fmt.Sprintf("%s[%d]", location, i), item, items, components)...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return mismatch("string")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch("boolean")
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || (number != math.Trunc(number)) {
+			return mismatch("integer")
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return mismatch("number")
+		}
+	}
+	return problems
+}
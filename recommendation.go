@@ -0,0 +1,80 @@
+package cperfc
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+type CgroupWrite struct {
+	SubSystem		string			`json:"subsystem"`
+	File			string			`json:"file"`
+	Old				string			`json:"old"`
+	New				string			`json:"new"`
+}
+
+type Recommendation struct {
+	Timestamp		time.Time		`json:"timestamp"`
+	Id				string			`json:"id"`
+	Policy			string			`json:"policy"`
+	Reason			string			`json:"reason"`
+	Usage			float64			`json:"usage"`
+	Writes			[]CgroupWrite	`json:"writes"`
+	LastSeen		time.Time		`json:"last_seen"`
+	Count			int				`json:"count"`			// times recommended in a row
+}
+
+type RecommendationManager struct {
+	lock			sync.RWMutex
+	Recommendations	[]Recommendation
+}
+
+const maxRecommendations = 1024
+
+var recommendationManager RecommendationManager
+
+func init() {
+}
+
+func GetRecommendations() *RecommendationManager {
+	return &recommendationManager
+}
+
+// Add collapses a recommendation into the last one of the container if it has the same writes,
+// since dry-run changes nothing and the same one is made every interval.
+func (self *RecommendationManager)Add(recommendation Recommendation) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for i := len(self.Recommendations) - 1; i >= 0; i-- {
+		last := &self.Recommendations[i]
+		if last.Id != recommendation.Id {
+			continue
+		}
+		if reflect.DeepEqual(last.Writes, recommendation.Writes) {
+			last.LastSeen = recommendation.Timestamp
+			last.Reason = recommendation.Reason
+			last.Usage = recommendation.Usage
+			last.Count++
+			return
+		}
+		break
+	}
+	recommendation.LastSeen = recommendation.Timestamp
+	recommendation.Count = 1
+	self.Recommendations = append(self.Recommendations, recommendation)
+	if len(self.Recommendations) > maxRecommendations {
+		self.Recommendations = self.Recommendations[len(self.Recommendations) - maxRecommendations:]
+	}
+}
+
+func (self *RecommendationManager)Get(cid string) []Recommendation {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	recommendations := []Recommendation{}
+	for _, recommendation := range self.Recommendations {
+		if (len(cid) == 0) || (recommendation.Id == cid) {
+			recommendations = append(recommendations, recommendation)
+		}
+	}
+	return recommendations
+}
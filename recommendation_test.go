@@ -0,0 +1,35 @@
+package cperfc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecommendationsCollapse(t *testing.T) {
+	grow := []CgroupWrite{{SubSystem: "cpuset", File: "cpuset.cpus", Old: "0", New: "0-1"}}
+	shrink := []CgroupWrite{{SubSystem: "cpuset", File: "cpuset.cpus", Old: "0-1", New: "0"}}
+	tests := []struct {
+		id				string
+		writes			[]CgroupWrite
+		expected		int				// recommendations after adding
+	}{
+		{"a", grow, 1},
+		{"a", grow, 1},
+		{"b", grow, 2},
+		{"a", grow, 2},
+		{"a", shrink, 3},
+		{"a", grow, 4},
+	}
+	manager := &RecommendationManager{}
+	now := time.Unix(1500000000, 0)
+	for i, test := range tests {
+		manager.Add(Recommendation{Timestamp: now.Add(time.Duration(i) * time.Second), Id: test.id, Writes: test.writes})
+		if len(manager.Recommendations) != test.expected {
+			t.Fatalf("%d: expected %d recommendations, got %d", i, test.expected, len(manager.Recommendations))
+		}
+	}
+	first := manager.Get("a")[0]
+	if (first.Count != 3) || !first.Timestamp.Equal(now) || !first.LastSeen.Equal(now.Add(3 * time.Second)) {
+		t.Errorf("expected 3 times from %s to %s, got %+v", now, now.Add(3 * time.Second), first)
+	}
+}
@@ -0,0 +1,117 @@
+package cperfc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"cperfc/config"
+	"cperfc/log"
+)
+
+var reloadLock sync.Mutex
+
+// restartSettings are only applied at startup.
+var restartSettings = []string{"state_dir", "trace", "openapi_check"}
+
+func init() {
+}
+
+// Reload re-reads the configuration and applies it at once, or keeps the previous one entirely on any error.
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	previous := config.Snapshot()
+	built, err := config.Build()
+	if err != nil {
+		return err
+	}
+	if err := config.Apply(built, ValidateConfig); err != nil {
+		return err
+	}
+	changed := changedSettings(previous, config.Snapshot())
+	if len(changed) == 0 {
+		log.Info("Configuration is reloaded, nothing is changed.")
+		return nil
+	}
+	if changed["listeners"] || changed["port"] || hasPrefix(changed, "tls.") || hasPrefix(changed, "socket.") {
+		if err := ReloadAPIListeners(); err != nil {
+			config.Restore(previous)
+			return fmt.Errorf("failed to reload API listeners, the previous configuration is kept: %s", err)
+		}
+	}
+	if hasPrefix(changed, "log.") {
+		if err := log.Logging(); err != nil {
+			rollback(previous)
+			return fmt.Errorf("failed to reload logging, the previous configuration is kept: %s", err)
+		}
+	}
+	if hasPrefix(changed, "audit.") {
+		if err := StartAudit(); err != nil {
+			rollback(previous)
+			return fmt.Errorf("failed to reload the audit log, the previous configuration is kept: %s", err)
+		}
+	}
+	if changed["auth_file"] {
+		if err := StartAuth(); err != nil {
+			rollback(previous)
+			return fmt.Errorf("failed to reload API authentication, the previous configuration is kept: %s", err)
+		}
+	}
+	if hasPrefix(changed, "webhooks.") {
+		if err := StartWebhooks(); err != nil {
+			rollback(previous)
+			return fmt.Errorf("failed to reload webhooks, the previous configuration is kept: %s", err)
+		}
+	}
+	if changed["interval"] {
+		ResetMainLoopInterval()
+	}
+	for _, key := range restartSettings {
+		if changed[key] {
+			log.Warnf("Configuration '%s' is changed, but needs a restart to be applied.", key)
+		}
+	}
+	for key, value := range config.GetOverrides() {
+		log.Infof("Runtime override %s=%v is kept over the configuration.", key, value)
+	}
+	var keys []string
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	log.Infof("Configuration is reloaded, changed: %s", strings.Join(keys, ", "))
+	return nil
+}
+
+// rollback applies the previous configuration again after a part of the new one is applied.
+func rollback(previous map[string]interface{}) {
+	config.Restore(previous)
+	log.Logging()
+	StartAudit()
+	StartAuth()
+	StartWebhooks()
+	ReloadAPIListeners()
+}
+
+func changedSettings(previous map[string]interface{}, current map[string]interface{}) map[string]bool {
+	changed := make(map[string]bool)
+	for key, value := range current {
+		if !reflect.DeepEqual(previous[key], value) {
+			changed[key] = true
+		}
+	}
+	return changed
+}
+
+func hasPrefix(keys map[string]bool, prefix string) bool {
+	for key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -19,34 +21,97 @@ import (
 	"cperfc/log"
 )
 
+type ContainerList struct {
+	Total			int				`json:"total"`
+	Offset			int				`json:"offset"`
+	Limit			int				`json:"limit"`
+	Containers		[]ContainerSummary	`json:"containers"`
+}
+
+// RegisteredResult is a bare JSON boolean of the deprecated reset API, true if the container is registered.
+type RegisteredResult bool
+
 type SimpleResult struct {
 	Result			bool			`json:"result"`
 	Desc			string			`json:"description"`
 }
 
+// procPath is where the processes are looked up for their containers.
+var procPath = "/proc"
+
 func init() {
 }
 
-func RESTfulAPIServe() {
-	log.Printf("Trying to initialize RESTful API port(%d).", config.ListeningPort)
+func newRESTfulRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/", restfulIndex)
-	router.HandleFunc("/control/{controlMsg}", restfulControl)
-	router.HandleFunc("/api/process/getcontainer/{pid}", restfulProcessGetContainer)
-	router.HandleFunc("/api/container/register/{cid}", restfulContainerRegister)
-	router.HandleFunc("/api/container/unregister/{cid}", restfulContainerUnregister)
-	router.HandleFunc("/api/container/isregistered/{cid}", restfulContainerIsRegistered)
-	router.HandleFunc("/api/container/status/{cid}", restfulContainerStatus)
-	router.HandleFunc("/api/container/set/cpu/{cid}", restfulContainerSetCPU)
-	router.HandleFunc("/api/container/set/cpuset/{cid}", restfulContainerSetCPUSet)
-	router.HandleFunc("/api/container/reset/cpu/{cid}", restfulContainerResetCPU)
-	router.HandleFunc("/api/container/reset/cpuset/{cid}", restfulContainerResetCPUSet)
+	router.HandleFunc("/", restfulIndex).Methods(legacyMethods...)
+	router.HandleFunc("/control/{controlMsg}", restfulDeprecated("/v1/control/{controlMsg}", restfulControl)).Methods(legacyMethods...)
+	router.HandleFunc("/api/process/getcontainer/{pid}", restfulDeprecated("/v1/processes/{pid}/container", restfulProcessGetContainer)).Methods(legacyMethods...)
+	router.HandleFunc("/api/containers", restfulDeprecated("/v1/containers", restfulContainers)).Methods(legacyMethods...)
+	router.HandleFunc("/api/host/containers", restfulDeprecated("/v1/host/containers", restfulHostContainers)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/register/{cid}", restfulDeprecated("/v1/containers/{cid}", restfulContainerRegister)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/unregister/{cid}", restfulDeprecated("/v1/containers/{cid}", restfulContainerUnregister)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/isregistered/{cid}", restfulDeprecated("/v1/containers/{cid}", restfulContainerIsRegistered)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/status/{cid}", restfulDeprecated("/v1/containers/{cid}", restfulContainerStatus)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/metrics/{cid}", restfulDeprecated("/v1/containers/{cid}/metrics", restfulContainerMetrics)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/set/cpu/{cid}", restfulDeprecated("/v1/containers/{cid}/cpu", restfulContainerSetCPU)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/set/cpuset/{cid}", restfulDeprecated("/v1/containers/{cid}/cpuset", restfulContainerSetCPUSet)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/set/policy/{cid}/{policy}", restfulDeprecated("/v1/containers/{cid}/policy", restfulContainerSetPolicy)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/set/slo/{cid}", restfulDeprecated("/v1/containers/{cid}/slo", restfulContainerSetSLO)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/report/{cid}", restfulDeprecated("/v1/containers/{cid}/reports", restfulContainerReport)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/set/dryrun/{cid}/{mode}", restfulDeprecated("/v1/containers/{cid}/dryrun", restfulContainerSetDryRun)).Methods(legacyMethods...)
+	router.HandleFunc("/api/recommendations", restfulDeprecated("/v1/recommendations", restfulRecommendations)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/reset/cpu/{cid}", restfulDeprecated("/v1/containers/{cid}/cpu", restfulContainerResetCPU)).Methods(legacyMethods...)
+	router.HandleFunc("/api/container/reset/cpuset/{cid}", restfulDeprecated("/v1/containers/{cid}/cpuset", restfulContainerResetCPUSet)).Methods(legacyMethods...)
+	restfulV1Serve(router)
+	router.HandleFunc("/openapi.json", restfulOpenAPI).Methods("GET")
+	router.HandleFunc("/api/stream", restfulStream).Methods("GET")
+	router.HandleFunc("/healthz", restfulHealthz).Methods("GET")
+	router.HandleFunc("/readyz", restfulReadyz).Methods("GET")
+	router.HandleFunc("/debug/status", restfulDebugStatus).Methods("GET")
+	router.PathPrefix("/debug/pprof/").HandlerFunc(restfulPprof).Methods("GET")
+	router.Use(settingsReader)
+	return router
+}
+
+// settingsReader keeps a reload from swapping the settings during a request,
+// except for the controls which change them and the long-running streams.
+func settingsReader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			template, _ := route.GetPathTemplate()
+			switch template {
+			case "/control/{controlMsg}", "/v1/control/{controlMsg}", "/api/stream", "/debug/pprof/":
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		config.RLock()
+		defer config.RUnlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func RESTfulAPIServe() error {
+	log.Printf("Trying to initialize RESTful API port(%d).", config.ListeningPort)
+	router := newRESTfulRouter()
+	for _, problem := range VerifyOpenAPIRoutes(router) {
+		log.Warn(problem)
+	}
+	handler := http.Handler(router)
+	if config.OpenAPICheck {
+		log.Printf("Validating responses against the OpenAPI document.")
+		handler = openAPIValidator(router)
+	}
+	if err := StartAuth(); err != nil {
+		return newDaemonError(config.EXITAUTH, "Failed to load API authentication[%s]: %s", config.AuthFile, err)
+	}
+	handler = authorizer(router, handler)
+	if err := StartAPIListeners(handler); err != nil {
+		return newDaemonError(config.EXITPORT, "Failed to start API listeners: %s", err)
+	}
 	log.Printf("APIs are ready.")
-	go func() {
-		err := http.ListenAndServe(":" + strconv.Itoa(config.ListeningPort), router)
-		log.Fatal(err)
-		finish(config.EXITPORT)
-	}()
+	return nil
 }
 
 func restfulControl(w http.ResponseWriter, r *http.Request) {
@@ -60,10 +125,22 @@ func restfulControl(w http.ResponseWriter, r *http.Request) {
 	case "pause":
 		StopMainLoop()
 		fmt.Fprintln(w, "paused")
+	case "dryrun":
+		config.Override("dry_run", true)
+		fmt.Fprintln(w, "dry-run")
+	case "live":
+		config.Override("dry_run", false)
+		fmt.Fprintln(w, "live")
+	case "reload":
+		if err := Reload(); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "reloaded")
 	case "exit":
-		StopMainLoop()
-		finish(config.EXITNORMAL)
-		fmt.Fprintln(w, "exited")
+		fmt.Fprintln(w, "exiting")
+		stopDaemon()
 	}
 }
 
@@ -87,7 +164,7 @@ func restfulProcessGetContainer(w http.ResponseWriter, r *http.Request) {
 		} else {
 			w.WriteHeader(http.StatusBadRequest)
 		}
-		json.NewEncoder(w).Encode(container)
+		json.NewEncoder(w).Encode(&container)
 	}()
 
 	outBuffer.WriteString("Process API: getcontainer\n")
@@ -99,7 +176,7 @@ func restfulProcessGetContainer(w http.ResponseWriter, r *http.Request) {
 	}
 	outBuffer.WriteString(fmt.Sprintf("The requested process ID is ''%s'\n", vars["pid"]))
 
-	basePath := path.Join("/proc/", vars["pid"])
+	basePath := path.Join(procPath, vars["pid"])
 	b, err := ioutil.ReadFile(path.Join(basePath, "cmdline"))
 	if err != nil {
 		outBuffer.WriteString(fmt.Sprintf("The process does not exist"))
@@ -119,6 +196,10 @@ func restfulProcessGetContainer(w http.ResponseWriter, r *http.Request) {
 	if scanner.Scan() {
 		cpusetLine := scanner.Text()
 		tokens := strings.Split(cpusetLine, "/")[1:]
+		if len(tokens) < 2 {
+			outBuffer.WriteString(fmt.Sprintf("Unknown cgroup '%s'\n", cpusetLine))
+			return
+		}
 		switch strings.ToLower(tokens[0]) {
 		case "docker":
 			outBuffer.WriteString("The process is of 'docker' container\n")
@@ -133,6 +214,65 @@ func restfulProcessGetContainer(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func restfulContainers(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var statusOK = false
+	var list = ContainerList{Containers: []ContainerSummary{}}
+
+	defer func() {
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if statusOK {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(list)
+	}()
+
+	outBuffer.WriteString("Process API: containers\n")
+	filter, err := parseContainerFilter(r.URL.Query())
+	if err != nil {
+		outBuffer.WriteString(fmt.Sprintln(err))
+		return
+	}
+
+	manager := GetContainerManager()
+	list.Containers, list.Total = manager.ListContainers(filter)
+	list.Offset = filter.Offset
+	list.Limit = filter.Limit
+	statusOK = true
+	outBuffer.WriteString(fmt.Sprintf("%d of %d containers\n", len(list.Containers), list.Total))
+}
+
+func restfulHostContainers(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var list = []HostContainer{}
+
+	defer func() {
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(list)
+	}()
+
+	outBuffer.WriteString("Process API: host containers\n")
+	managed := strings.ToLower(r.URL.Query().Get("managed"))
+	for _, container := range GetHostContainers() {
+		if ((managed == "true") && !container.Managed) || ((managed == "false") && container.Managed) {
+			continue
+		}
+		list = append(list, container)
+	}
+	outBuffer.WriteString(fmt.Sprintf("%d containers on the host\n", len(list)))
+}
+
 func restfulContainerRegister(w http.ResponseWriter, r *http.Request) {
 	var outBuffer bytes.Buffer
 	var result = SimpleResult{Result: false}
@@ -166,10 +306,17 @@ func restfulContainerRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy := strings.ToLower(r.URL.Query().Get("policy"))
+	if (len(policy) > 0) && !IsScalingPolicy(policy) {
+		result.Desc = fmt.Sprintf("Unknown policy. Available policies are %s", GetScalingPolicyNames())
+		return
+	}
+
 	container.Id = cid
+	container.CgroupRequest.Policy = policy
 	container.Type = cgroups.GetContainerType(cid)
 	container.Path = cgroups.GetContainerFullPath(config.CpuSetSubSystem, cid)[0]
-	container.CAdvisorInfo, _ = GetContainerInfo(container)
+	container.CAdvisorInfo, _ = GetContainerInfo(&container)
 	manager.AddContainer(&container)
 	result.Result = true
 	result.Desc = fmt.Sprintf("The container is registered")
@@ -229,7 +376,7 @@ func restfulContainerIsRegistered(w http.ResponseWriter, r *http.Request) {
 	if manager.IsContainerRegistered(cid) {
 		if !cgroups.IsContainerExist(cid) {
 			result.Desc = fmt.Sprintf("The container is registered, but disappeared... clean up")
-			manager.RemoveContainer(cid)
+			manager.RemoveDisappearedContainer(cid)
 			return
 		}
 		result.Result = true
@@ -266,7 +413,7 @@ func restfulContainerStatus(w http.ResponseWriter, r *http.Request) {
 	if manager.IsContainerRegistered(cid) {
 		if !cgroups.IsContainerExist(cid) {
 			outBuffer.WriteString(fmt.Sprintf("The container is registered, but disappeared... clean up\n"))
-			manager.RemoveContainer(cid)
+			manager.RemoveDisappearedContainer(cid)
 			return
 		}
 		container, _ = manager.GetContainers(cid)
@@ -275,15 +422,443 @@ func restfulContainerStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func restfulContainerMetrics(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var statusOK = false
+	var samples = []Sample{}
+
+	defer func() {
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if statusOK {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(samples)
+	}()
+
+	outBuffer.WriteString("Process API: metrics\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s'\n", cid))
+
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		outBuffer.WriteString(fmt.Sprintf("Wrong 'from': %s\n", query.Get("from")))
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		outBuffer.WriteString(fmt.Sprintf("Wrong 'to': %s\n", query.Get("to")))
+		return
+	}
+	step, err := parseDurationParam(query.Get("step"))
+	if err != nil {
+		outBuffer.WriteString(fmt.Sprintf("Wrong 'step': %s\n", query.Get("step")))
+		return
+	}
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		outBuffer.WriteString(fmt.Sprintf("The container is not registered\n"))
+		return
+	}
+	statusOK = true
+	if container.History == nil {
+		return
+	}
+	samples = Downsample(container.History.GetRange(from, to), step)
+	if samples == nil {
+		samples = []Sample{}
+	}
+	outBuffer.WriteString(fmt.Sprintf("%d samples\n", len(samples)))
+}
+
+func restfulContainerSetSLO(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var result = SimpleResult{Result: false}
+
+	defer func() {
+		outBuffer.WriteString(result.Desc)
+		outBuffer.WriteString("\n")
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}()
+
+	outBuffer.WriteString("Process API: set slo\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s'\n", cid))
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		result.Desc = fmt.Sprintf("The container is not registered")
+		return
+	}
+	container.lock.Lock()
+	request := container.CgroupRequest.SLO
+	container.lock.Unlock()
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		result.Desc = fmt.Sprintf("Wrong slo request: %s", err)
+		return
+	}
+	if (request.Target < 0) || (request.Lower < 0) || (request.StaleAfter < 0) {
+		result.Desc = fmt.Sprintf("Negative slo values")
+		return
+	}
+	container.lock.Lock()
+	container.CgroupRequest.SLO = request
+	container.lock.Unlock()
+	manager.store()
+	result.Result = true
+	result.Desc = fmt.Sprintf("The slo request is set: %s", JSONStructureToString(request))
+}
+
+func restfulContainerReport(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var result = SimpleResult{Result: false}
+
+	defer func() {
+		outBuffer.WriteString(result.Desc)
+		outBuffer.WriteString("\n")
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Debug(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}()
+
+	outBuffer.WriteString("Process API: report\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s'\n", cid))
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		result.Desc = fmt.Sprintf("The container is not registered")
+		return
+	}
+	container.lock.Lock()
+	report := SLOReport{Metric: container.CgroupRequest.SLO.Metric}
+	container.lock.Unlock()
+	query := r.URL.Query()
+	if len(query.Get("value")) > 0 {
+		value, err := strconv.ParseFloat(query.Get("value"), 64)
+		if err != nil {
+			result.Desc = fmt.Sprintf("Wrong value: %s", query.Get("value"))
+			return
+		}
+		report.Value = value
+		if len(query.Get("metric")) > 0 {
+			report.Metric = query.Get("metric")
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		result.Desc = fmt.Sprintf("Wrong report: %s", err)
+		return
+	}
+	if report.Timestamp.IsZero() {
+		report.Timestamp = time.Now()
+	}
+	container.lock.Lock()
+	container.SLOReport = &report
+	container.lock.Unlock()
+	result.Result = true
+	result.Desc = fmt.Sprintf("%s = %f", report.Metric, report.Value)
+}
+
+func restfulContainerSetDryRun(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var result = SimpleResult{Result: false}
+
+	defer func() {
+		outBuffer.WriteString(result.Desc)
+		outBuffer.WriteString("\n")
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}()
+
+	outBuffer.WriteString("Process API: set dryrun\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s', mode is '%s'\n", cid, vars["mode"]))
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		result.Desc = fmt.Sprintf("The container is not registered")
+		return
+	}
+	var dryRun bool
+	switch strings.ToLower(vars["mode"]) {
+	case "on", "true":
+		dryRun = true
+	case "off", "false":
+		dryRun = false
+	default:
+		result.Desc = fmt.Sprintf("Wrong mode. Use 'on' or 'off'")
+		return
+	}
+	container.lock.Lock()
+	container.CgroupRequest.DryRun = dryRun
+	container.lock.Unlock()
+	manager.store()
+	result.Result = true
+	result.Desc = fmt.Sprintf("The dry-run mode is %t", dryRun)
+}
+
+func restfulRecommendations(w http.ResponseWriter, r *http.Request) {
+	cid := r.URL.Query().Get("cid")
+	recommendations := GetRecommendations().Get(cid)
+	log.Printf("Process API: recommendations\n\t%d recommendations", len(recommendations))
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(recommendations)
+}
+
+func parseContainerFilter(query url.Values) (ContainerFilter, error) {
+	var err error
+
+	filter := ContainerFilter{Type: query.Get("type"), Image: query.Get("image"), Labels: make(map[string]string)}
+	for _, label := range query["label"] {
+		tokens := strings.SplitN(label, "=", 2)
+		if len(tokens) == 2 {
+			filter.Labels[tokens[0]] = tokens[1]
+		} else {
+			filter.Labels[tokens[0]] = ""
+		}
+	}
+	numbers := []struct {
+		name	string
+		value	*float64
+	}{{"min_usage", &filter.MinUsage}, {"max_usage", &filter.MaxUsage}}
+	for _, number := range numbers {
+		if len(query.Get(number.name)) == 0 {
+			continue
+		}
+		if *number.value, err = strconv.ParseFloat(query.Get(number.name), 64); err != nil {
+			return filter, fmt.Errorf("Wrong '%s': %s", number.name, query.Get(number.name))
+		}
+	}
+	integers := []struct {
+		name	string
+		value	*int
+	}{{"offset", &filter.Offset}, {"limit", &filter.Limit}}
+	for _, integer := range integers {
+		if len(query.Get(integer.name)) == 0 {
+			continue
+		}
+		if *integer.value, err = strconv.Atoi(query.Get(integer.name)); err != nil || *integer.value < 0 {
+			return filter, fmt.Errorf("Wrong '%s': %s", integer.name, query.Get(integer.name))
+		}
+	}
+	filter.SortBy = strings.ToLower(query.Get("sort"))
+	switch filter.SortBy {
+	case "", "id", "usage", "cores":
+	default:
+		return filter, fmt.Errorf("Wrong 'sort': %s. Use one of {id, usage, cores}", filter.SortBy)
+	}
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("Wrong 'order': %s. Use one of {asc, desc}", query.Get("order"))
+	}
+	return filter, nil
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseDurationParam(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
 func restfulContainerSetCPU(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var result = SimpleResult{Result: false}
+
+	defer func() {
+		outBuffer.WriteString(result.Desc)
+		outBuffer.WriteString("\n")
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}()
+
+	outBuffer.WriteString("Process API: set cpu\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s'\n", cid))
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		result.Desc = fmt.Sprintf("The container is not registered")
+		return
+	}
+	container.lock.Lock()
+	request := container.CgroupRequest.CPU
+	container.lock.Unlock()
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		result.Desc = fmt.Sprintf("Wrong cpu request: %s", err)
+		return
+	}
+	container.lock.Lock()
+	if len(request.Shares) > 0 {
+		err := cgroups.SetCPUShares(cid, request.Shares)
+		auditAPIWrite(r, cid, CgroupWrite{SubSystem: config.CpuSubSystem, File: "cpu.shares", Old: container.CgroupCurrent.CPU.Shares, New: request.Shares}, err)
+		if err != nil {
+			container.lock.Unlock()
+			result.Desc = fmt.Sprintf("Failed to set cpu.shares: %s", err)
+			return
+		}
+		container.CgroupCurrent.CPU.Shares = request.Shares
+	}
+	container.CgroupRequest.CPU = request
+	container.lock.Unlock()
+	manager.store()
+	result.Result = true
+	result.Desc = fmt.Sprintf("The cpu request is set: %s", JSONStructureToString(request))
 }
 
 func restfulContainerSetCPUSet(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var result = SimpleResult{Result: false}
+
+	defer func() {
+		outBuffer.WriteString(result.Desc)
+		outBuffer.WriteString("\n")
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}()
+
+	outBuffer.WriteString("Process API: set cpuset\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s'\n", cid))
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		result.Desc = fmt.Sprintf("The container is not registered")
+		return
+	}
+	container.lock.Lock()
+	request := container.CgroupRequest.CPUSet
+	container.lock.Unlock()
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		result.Desc = fmt.Sprintf("Wrong cpuset request: %s", err)
+		return
+	}
+	if (request.MaxCores > 0) && (request.MinCores > request.MaxCores) {
+		result.Desc = fmt.Sprintf("min_cores(%d) is larger than max_cores(%d)", request.MinCores, request.MaxCores)
+		return
+	}
+	container.lock.Lock()
+	if len(request.CPUS) > 0 {
+		err := cgroups.SetCPUSet(cid, request.CPUS)
+		auditAPIWrite(r, cid, CgroupWrite{SubSystem: config.CpuSetSubSystem, File: "cpuset.cpus", Old: container.CgroupCurrent.CPUSet.CPUS, New: request.CPUS}, err)
+		if err != nil {
+			container.lock.Unlock()
+			result.Desc = fmt.Sprintf("Failed to set cpuset.cpus: %s", err)
+			return
+		}
+		container.CgroupCurrent.CPUSet.CPUS = request.CPUS
+	}
+	container.CgroupRequest.CPUSet = request
+	container.lock.Unlock()
+	manager.store()
+	result.Result = true
+	result.Desc = fmt.Sprintf("The cpuset request is set: %s", JSONStructureToString(request))
+}
+
+func restfulContainerSetPolicy(w http.ResponseWriter, r *http.Request) {
+	var outBuffer bytes.Buffer
+	var result = SimpleResult{Result: false}
+
+	defer func() {
+		outBuffer.WriteString(result.Desc)
+		outBuffer.WriteString("\n")
+		text := outBuffer.String()
+		prettyText := strings.Replace(text, "\n", "\n\t", -1)
+		log.Println(prettyText)
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}()
+
+	outBuffer.WriteString("Process API: set policy\n")
+	vars := mux.Vars(r)
+	cid := vars["cid"]
+	policy := strings.ToLower(vars["policy"])
+	outBuffer.WriteString(fmt.Sprintf("The requested container ID is '%s', policy is '%s'\n", cid, policy))
+
+	manager := GetContainerManager()
+	container, exist := manager.GetContainers(cid)
+	if !exist {
+		result.Desc = fmt.Sprintf("The container is not registered")
+		return
+	}
+	if !IsScalingPolicy(policy) {
+		result.Desc = fmt.Sprintf("Unknown policy. Available policies are %s", GetScalingPolicyNames())
+		return
+	}
+	container.lock.Lock()
+	container.CgroupRequest.Policy = policy
+	container.lock.Unlock()
+	manager.store()
+	result.Result = true
+	result.Desc = fmt.Sprintf("The policy is set")
 }
 
 func restfulContainerResetCPU(w http.ResponseWriter, r *http.Request) {
 	var outBuffer bytes.Buffer
-	var registered = false
+	var registered = RegisteredResult(false)
 
 	defer func() {
 		text := outBuffer.String()
@@ -305,7 +880,7 @@ func restfulContainerResetCPU(w http.ResponseWriter, r *http.Request) {
 		outBuffer.WriteString(fmt.Sprintf("The requested container is in the list\n"))
 		if !cgroups.IsContainerExist(cid) {
 			outBuffer.WriteString(fmt.Sprintf("Oops, Not exist. remove in the list\n"))
-			manager.RemoveContainer(cid)
+			manager.RemoveDisappearedContainer(cid)
 			return
 		}
 	} else {
@@ -313,7 +888,13 @@ func restfulContainerResetCPU(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	registered = true
-	cgroups.ResetCgroupInfo(cid)
+	container, _ := manager.GetContainers(cid)
+	for _, subSystem := range []string{config.CpuSetSubSystem, config.CpuSubSystem} {
+		if err := resetContainerCgroup(r, container, subSystem); err != nil {
+			log.Warn(err)
+		}
+	}
+	manager.store()
 }
 
 func restfulContainerResetCPUSet(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,181 @@
+package cperfc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	cAdvisorInfo "github.com/google/cadvisor/info/v1"
+	"github.com/gorilla/mux"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+)
+
+// openAPITestValues fills the path parameters of the routes.
+var openAPITestValues = map[string]string{
+	"cid": "test",
+	"pid": "1",
+	"policy": "none",
+	"mode": "true",
+	"controlMsg": "reload",
+}
+
+// openAPITestSkipped never finishes or stops the process.
+var openAPITestSkipped = map[string]bool{
+	"GET /api/stream": true,
+}
+
+// openAPITestUnregistered are the operations registering the container, by a method and path or by a path for any method.
+var openAPITestUnregistered = map[string]bool{
+	"POST /v1/containers/{cid}": true,
+	"/api/container/register/{cid}": true,
+}
+
+func openAPITestPath(template string) string {
+	return openAPIPathParameter.ReplaceAllStringFunc(template, func(parameter string) string {
+		return openAPITestValues[strings.Trim(parameter, "{}")]
+	})
+}
+
+func writeTestFile(t *testing.T, name string, text string) {
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupOpenAPITest makes a cgroup tree with the container 'test', the process 1 in it,
+// a cAdvisor only with the machine and a configuration to reload.
+func setupOpenAPITest(t *testing.T) {
+	cAdvisor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/machine") {
+			json.NewEncoder(w).Encode(cAdvisorInfo.MachineInfo{NumCores: 4})
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	saved := config.Snapshot()
+	configFile, subSystems, proc := config.ConfigFile, *cgroups.GetSubSystemManager(), procPath
+	t.Cleanup(func() {
+		cAdvisor.Close()
+		config.Restore(saved)
+		config.ConfigFile, *cgroups.GetSubSystemManager(), procPath = configFile, subSystems, proc
+	})
+	root := t.TempDir()
+	writeTestFile(t, path.Join(root, "cpuset/docker/cpuset.cpus"), "0-3\n")
+	writeTestFile(t, path.Join(root, "cpuset/docker/test/cpuset.cpus"), "0-1\n")
+	writeTestFile(t, path.Join(root, "cpu/docker/test/cpu.shares"), "1024\n")
+	*cgroups.GetSubSystemManager() = cgroups.SubSystemManager{
+		SubSystem: []string{config.CpuSetSubSystem, config.CpuSubSystem},
+		Path: map[string]string{config.CpuSetSubSystem: path.Join(root, "cpuset"), config.CpuSubSystem: path.Join(root, "cpu")},
+	}
+	procPath = path.Join(root, "proc")
+	writeTestFile(t, path.Join(procPath, "1/cmdline"), "/usr/bin/test")
+	writeTestFile(t, path.Join(procPath, "1/cgroup"), "4:cpuset:/docker/test\n3:cpu,cpuacct:/docker/test\n")
+	config.StateDir = t.TempDir()
+	config.CAdvisorAddr = cAdvisor.URL
+	config.Pprof = true
+	config.ConfigFile = path.Join(root, "cperfc.yaml")
+	writeTestFile(t, config.ConfigFile, fmt.Sprintf("state_dir: %s\ncadvisor: %s\ndebug:\n  pprof: true\n", config.StateDir, config.CAdvisorAddr))
+	NewContainerManager()
+	GetCAdvisor().Probe()
+}
+
+// registerTestContainer registers the container 'test' again, as an operation may change or unregister it.
+func registerTestContainer(registered bool) {
+	manager := GetContainerManager()
+	manager.RemoveContainer("test")
+	if registered {
+		manager.AddContainer(&Container{Id: "test", Type: config.DockerName, Path: cgroups.GetContainerFullPath(config.CpuSetSubSystem, "test")[0]})
+	}
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	router := newRESTfulRouter()
+	for _, problem := range VerifyOpenAPIRoutes(router) {
+		t.Error(problem)
+	}
+	for _, operation := range openAPIOperations {
+		var match mux.RouteMatch
+
+		template := ""
+		request := httptest.NewRequest(operation.Method, openAPITestPath(operation.Path), nil)
+		if router.Match(request, &match) && (match.Route != nil) {
+			template, _ = match.Route.GetPathTemplate()
+		}
+		if template != operation.Path {
+			t.Errorf("%s %s is documented, but not routed", operation.Method, operation.Path)
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	setupOpenAPITest(t)
+	router := newRESTfulRouter()
+	for _, operation := range openAPIOperations {
+		key := operation.Method + " " + operation.Path
+		if openAPITestSkipped[key] {
+			continue
+		}
+		registerTestContainer(!openAPITestUnregistered[key] && !openAPITestUnregistered[operation.Path])
+		var body bytes.Buffer
+		if operation.Request != nil {
+			json.NewEncoder(&body).Encode(operation.Request)
+		}
+		request := httptest.NewRequest(operation.Method, openAPITestPath(operation.Path), &body)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		problems := ValidateOpenAPIResponse(operation.Method, operation.Path, recorder.Code, recorder.Body.Bytes())
+		for _, problem := range problems {
+			t.Errorf("%s -> %d: %s", key, recorder.Code, problem)
+		}
+		if (len(problems) == 0) && ((recorder.Code < 200) || (recorder.Code >= 300)) {
+			for status := range operation.Responses {
+				if (status >= 200) && (status < 300) {
+					t.Errorf("%s -> %d: no successful response is validated: %s", key, recorder.Code, recorder.Body.String())
+					break
+				}
+			}
+		}
+	}
+	registerTestContainer(false)
+}
+
+func TestDisappearedContainerKept(t *testing.T) {
+	setupOpenAPITest(t)
+	GetContainerManager().AddContainer(&Container{Id: "gone", Type: config.DockerName})
+	defer GetContainerManager().RemoveContainer("gone")
+	router := newRESTfulRouter()
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/containers/gone", nil))
+		if (recorder.Code != http.StatusNotFound) || !strings.Contains(recorder.Body.String(), ErrorContainerDisappeared) {
+			t.Errorf("expected %s, got %d %s", ErrorContainerDisappeared, recorder.Code, recorder.Body.String())
+		}
+	}
+	if !GetContainerManager().IsContainerRegistered("gone") {
+		t.Error("a GET removes the disappeared container")
+	}
+}
+
+func TestLegacyReload(t *testing.T) {
+	setupOpenAPITest(t)
+	configFile := config.ConfigFile
+	defer func() { config.ConfigFile = configFile }()
+	config.ConfigFile = path.Join(t.TempDir(), "none.yaml")
+	recorder := httptest.NewRecorder()
+	newRESTfulRouter().ServeHTTP(recorder, httptest.NewRequest("POST", "/control/reload", nil))
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a failed reload to be %d, got %d %s", http.StatusUnprocessableEntity, recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,533 @@
+package cperfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+	"cperfc/log"
+)
+
+const (
+	ErrorBadRequest = "bad_request"
+	ErrorNotFound = "not_found"
+	ErrorContainerNotExist = "container_not_exist"
+	ErrorContainerDisappeared = "container_disappeared"
+	ErrorAlreadyRegistered = "already_registered"
+	ErrorInvalidPolicy = "invalid_policy"
+	ErrorInvalidRequest = "invalid_request"
+	ErrorCgroup = "cgroup_error"
+	ErrorInvalidConfig = "invalid_config"
+)
+
+type APIError struct {
+	Status			int				`json:"-"`
+	Code			string			`json:"code"`
+	Message			string			`json:"message"`
+}
+
+type APIErrorEnvelope struct {
+	Error			*APIError		`json:"error"`
+}
+
+type PolicyRequest struct {
+	Policy			string			`json:"policy"`
+}
+
+type DryRunRequest struct {
+	DryRun			bool			`json:"dry_run"`
+}
+
+type LogLevelRequest struct {
+	Default			string				`json:"default,omitempty"`
+	Modules			map[string]string	`json:"modules,omitempty"`		// empty level to remove the module level
+}
+
+func init() {
+}
+
+func (self *APIError)Error() string {
+	return fmt.Sprintf("%d %s: %s", self.Status, self.Code, self.Message)
+}
+
+func newAPIError(status int, code string, format string, args ...interface{}) *APIError {
+	return &APIError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func restfulV1Serve(router *mux.Router) {
+	v1 := router.PathPrefix("/v1").Subrouter()
+	v1.HandleFunc("/containers", restfulV1Containers).Methods("GET")
+	v1.HandleFunc("/containers/{cid}", restfulV1ContainerStatus).Methods("GET")
+	v1.HandleFunc("/containers/{cid}", restfulV1ContainerRegister).Methods("POST")
+	v1.HandleFunc("/containers/{cid}", restfulV1ContainerUnregister).Methods("DELETE")
+	v1.HandleFunc("/containers/{cid}/metrics", restfulV1ContainerMetrics).Methods("GET")
+	v1.HandleFunc("/containers/{cid}/reports", restfulV1ContainerReport).Methods("POST")
+	v1.HandleFunc("/containers/{cid}/cpu", restfulV1ContainerSetCPU).Methods("PUT")
+	v1.HandleFunc("/containers/{cid}/cpu", restfulV1ContainerReset).Methods("DELETE")
+	v1.HandleFunc("/containers/{cid}/cpuset", restfulV1ContainerSetCPUSet).Methods("PUT")
+	v1.HandleFunc("/containers/{cid}/cpuset", restfulV1ContainerReset).Methods("DELETE")
+	v1.HandleFunc("/containers/{cid}/policy", restfulV1ContainerSetPolicy).Methods("PUT")
+	v1.HandleFunc("/containers/{cid}/slo", restfulV1ContainerSetSLO).Methods("PUT")
+	v1.HandleFunc("/containers/{cid}/dryrun", restfulV1ContainerSetDryRun).Methods("PUT")
+	v1.HandleFunc("/host/containers", restfulHostContainers).Methods("GET")
+	v1.HandleFunc("/recommendations", restfulRecommendations).Methods("GET")
+	v1.HandleFunc("/events", restfulV1Events).Methods("GET")
+	v1.HandleFunc("/audit", restfulV1Audit).Methods("GET")
+	v1.HandleFunc("/log/levels", restfulV1LogLevels).Methods("GET")
+	v1.HandleFunc("/log/levels", restfulV1SetLogLevels).Methods("PUT")
+	v1.HandleFunc("/processes/{pid}/container", restfulV1ProcessGetContainer).Methods("GET")
+	v1.HandleFunc("/control/{controlMsg}", restfulV1Control).Methods("POST")
+}
+
+func restfulDeprecated(successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Warnf("Deprecated API %s, use %s", r.URL.Path, successor)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		handler(w, r)
+	}
+}
+
+func writeV1(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	log.Printf("API v1: %s %s -> %d", r.Method, r.URL.Path, status)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func writeV1Error(w http.ResponseWriter, r *http.Request, err *APIError) {
+	log.Warnf("API v1: %s %s -> %s", r.Method, r.URL.Path, err)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(APIErrorEnvelope{Error: err})
+}
+
+func decodeV1Body(r *http.Request, v interface{}) *APIError {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if (err != nil) && (err != io.EOF) {
+		return newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong request body: %s", err)
+	}
+	return nil
+}
+
+// getRegisteredContainer does not remove a disappeared container, the main loop does.
+func getRegisteredContainer(cid string) (*Container, *APIError) {
+	container, exist := GetContainerManager().GetContainers(cid)
+	if !exist {
+		return nil, newAPIError(http.StatusNotFound, ErrorNotFound, "The container '%s' is not registered", cid)
+	}
+	if !cgroups.IsContainerExist(cid) {
+		return nil, newAPIError(http.StatusNotFound, ErrorContainerDisappeared, "The container '%s' is registered, but disappeared", cid)
+	}
+	return container, nil
+}
+
+func registerContainer(cid string, policy string) (*Container, *APIError) {
+	manager := GetContainerManager()
+	policy = strings.ToLower(policy)
+	if (len(policy) > 0) && !IsScalingPolicy(policy) {
+		return nil, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidPolicy, "Unknown policy '%s'. Available policies are %s", policy, GetScalingPolicyNames())
+	}
+	if !cgroups.IsContainerExist(cid) {
+		return nil, newAPIError(http.StatusNotFound, ErrorContainerNotExist, "The container '%s' does not exist", cid)
+	}
+	if manager.IsContainerRegistered(cid) {
+		return nil, newAPIError(http.StatusConflict, ErrorAlreadyRegistered, "The container '%s' is already registered", cid)
+	}
+	container := Container{Id: cid}
+	container.CgroupRequest.Policy = policy
+	container.Type = cgroups.GetContainerType(cid)
+	container.Path = cgroups.GetContainerFullPath(config.CpuSetSubSystem, cid)[0]
+	container.CAdvisorInfo, _ = GetContainerInfo(&container)
+	manager.AddContainer(&container)
+	registered, _ := manager.GetContainers(cid)
+	return registered, nil
+}
+
+func restfulV1Containers(w http.ResponseWriter, r *http.Request) {
+	var list ContainerList
+
+	filter, err := parseContainerFilter(r.URL.Query())
+	if err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "%s", err))
+		return
+	}
+	list.Containers, list.Total = GetContainerManager().ListContainers(filter)
+	list.Offset = filter.Offset
+	list.Limit = filter.Limit
+	writeV1(w, r, http.StatusOK, list)
+}
+
+func restfulV1Events(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, r, http.StatusOK, EventBusStatus{Metrics: GetEventMetrics(), Subscribers: GetEventBus().Stats()})
+}
+
+func restfulV1LogLevels(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, r, http.StatusOK, log.GetLevels())
+}
+
+func restfulV1SetLogLevels(w http.ResponseWriter, r *http.Request) {
+	var request LogLevelRequest
+
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if len(request.Default) > 0 {
+		if err := log.SetLevel("", request.Default); err != nil {
+			writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidRequest, "default: %s", err))
+			return
+		}
+	}
+	for module, level := range request.Modules {
+		if err := log.SetLevel(module, level); err != nil {
+			writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidRequest, "%s: %s", module, err))
+			return
+		}
+	}
+	log.Infof("Log levels are changed by '%s': %v", GetAPIIdentity(r).Name, log.GetLevels().Modules)
+	writeV1(w, r, http.StatusOK, log.GetLevels())
+}
+
+func restfulV1ContainerStatus(w http.ResponseWriter, r *http.Request) {
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	writeV1(w, r, http.StatusOK, container)
+}
+
+func restfulV1ContainerRegister(w http.ResponseWriter, r *http.Request) {
+	var request PolicyRequest
+
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if policy := r.URL.Query().Get("policy"); len(policy) > 0 {
+		request.Policy = policy
+	}
+	container, err := registerContainer(mux.Vars(r)["cid"], request.Policy)
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	w.Header().Set("Location", path.Join("/v1/containers", container.Id))
+	writeV1(w, r, http.StatusCreated, container)
+}
+
+func restfulV1ContainerUnregister(w http.ResponseWriter, r *http.Request) {
+	cid := mux.Vars(r)["cid"]
+	if !GetContainerManager().RemoveContainer(cid) {
+		writeV1Error(w, r, newAPIError(http.StatusNotFound, ErrorNotFound, "The container '%s' is not registered", cid))
+		return
+	}
+	writeV1(w, r, http.StatusNoContent, nil)
+}
+
+func restfulV1ContainerMetrics(w http.ResponseWriter, r *http.Request) {
+	container, apiErr := getRegisteredContainer(mux.Vars(r)["cid"])
+	if apiErr != nil {
+		writeV1Error(w, r, apiErr)
+		return
+	}
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong 'from': %s", query.Get("from")))
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong 'to': %s", query.Get("to")))
+		return
+	}
+	step, err := parseDurationParam(query.Get("step"))
+	if err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong 'step': %s", query.Get("step")))
+		return
+	}
+	samples := []Sample{}
+	if container.History != nil {
+		if downsampled := Downsample(container.History.GetRange(from, to), step); downsampled != nil {
+			samples = downsampled
+		}
+	}
+	writeV1(w, r, http.StatusOK, samples)
+}
+
+func restfulV1ContainerReport(w http.ResponseWriter, r *http.Request) {
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	container.lock.Lock()
+	report := SLOReport{Metric: container.CgroupRequest.SLO.Metric}
+	container.lock.Unlock()
+	if err := decodeV1Body(r, &report); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if report.Timestamp.IsZero() {
+		report.Timestamp = time.Now()
+	}
+	container.lock.Lock()
+	container.SLOReport = &report
+	container.lock.Unlock()
+	writeV1(w, r, http.StatusAccepted, report)
+}
+
+func restfulV1ContainerSetCPU(w http.ResponseWriter, r *http.Request) {
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	container.lock.Lock()
+	request := container.CgroupRequest.CPU
+	container.lock.Unlock()
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if request.ThreshMin > request.ThreshMax {
+		writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidRequest, "thresh_min(%d) is larger than thresh_max(%d)", request.ThreshMin, request.ThreshMax))
+		return
+	}
+	container.lock.Lock()
+	if len(request.Shares) > 0 {
+		err := cgroups.SetCPUShares(container.Id, request.Shares)
+		auditAPIWrite(r, container.Id, CgroupWrite{SubSystem: config.CpuSubSystem, File: "cpu.shares", Old: container.CgroupCurrent.CPU.Shares, New: request.Shares}, err)
+		if err != nil {
+			container.lock.Unlock()
+			writeV1Error(w, r, newAPIError(http.StatusInternalServerError, ErrorCgroup, "Failed to set cpu.shares: %s", err))
+			return
+		}
+		container.CgroupCurrent.CPU.Shares = request.Shares
+	}
+	container.CgroupRequest.CPU = request
+	cgroupRequest := container.CgroupRequest
+	container.lock.Unlock()
+	GetContainerManager().store()
+	writeV1(w, r, http.StatusOK, cgroupRequest)
+}
+
+func restfulV1ContainerSetCPUSet(w http.ResponseWriter, r *http.Request) {
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	container.lock.Lock()
+	request := container.CgroupRequest.CPUSet
+	container.lock.Unlock()
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if request.ThreshMin > request.ThreshMax {
+		writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidRequest, "thresh_min(%d) is larger than thresh_max(%d)", request.ThreshMin, request.ThreshMax))
+		return
+	}
+	if (request.MaxCores > 0) && (request.MinCores > request.MaxCores) {
+		writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidRequest, "min_cores(%d) is larger than max_cores(%d)", request.MinCores, request.MaxCores))
+		return
+	}
+	container.lock.Lock()
+	if len(request.CPUS) > 0 {
+		err := cgroups.SetCPUSet(container.Id, request.CPUS)
+		auditAPIWrite(r, container.Id, CgroupWrite{SubSystem: config.CpuSetSubSystem, File: "cpuset.cpus", Old: container.CgroupCurrent.CPUSet.CPUS, New: request.CPUS}, err)
+		if err != nil {
+			container.lock.Unlock()
+			writeV1Error(w, r, newAPIError(http.StatusInternalServerError, ErrorCgroup, "Failed to set cpuset.cpus: %s", err))
+			return
+		}
+		container.CgroupCurrent.CPUSet.CPUS = request.CPUS
+	}
+	container.CgroupRequest.CPUSet = request
+	cgroupRequest := container.CgroupRequest
+	container.lock.Unlock()
+	GetContainerManager().store()
+	writeV1(w, r, http.StatusOK, cgroupRequest)
+}
+
+func restfulV1ContainerReset(w http.ResponseWriter, r *http.Request) {
+	container, apiErr := getRegisteredContainer(mux.Vars(r)["cid"])
+	if apiErr != nil {
+		writeV1Error(w, r, apiErr)
+		return
+	}
+	subSystem := path.Base(r.URL.Path)
+	if err := resetContainerCgroup(r, container, subSystem); err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusInternalServerError, ErrorCgroup, "Failed to reset %s: %s", subSystem, err))
+		return
+	}
+	GetContainerManager().store()
+	writeV1(w, r, http.StatusNoContent, nil)
+}
+
+func restfulV1ContainerSetPolicy(w http.ResponseWriter, r *http.Request) {
+	var request PolicyRequest
+
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	request.Policy = strings.ToLower(request.Policy)
+	if (len(request.Policy) > 0) && !IsScalingPolicy(request.Policy) {
+		writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidPolicy, "Unknown policy '%s'. Available policies are %s", request.Policy, GetScalingPolicyNames()))
+		return
+	}
+	container.lock.Lock()
+	container.CgroupRequest.Policy = request.Policy
+	cgroupRequest := container.CgroupRequest
+	container.lock.Unlock()
+	GetContainerManager().store()
+	writeV1(w, r, http.StatusOK, cgroupRequest)
+}
+
+func restfulV1ContainerSetSLO(w http.ResponseWriter, r *http.Request) {
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	container.lock.Lock()
+	request := container.CgroupRequest.SLO
+	container.lock.Unlock()
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if (request.Target < 0) || (request.Lower < 0) || (request.StaleAfter < 0) {
+		writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidRequest, "Negative slo values"))
+		return
+	}
+	container.lock.Lock()
+	container.CgroupRequest.SLO = request
+	cgroupRequest := container.CgroupRequest
+	container.lock.Unlock()
+	GetContainerManager().store()
+	writeV1(w, r, http.StatusOK, cgroupRequest)
+}
+
+func restfulV1ContainerSetDryRun(w http.ResponseWriter, r *http.Request) {
+	var request DryRunRequest
+
+	container, err := getRegisteredContainer(mux.Vars(r)["cid"])
+	if err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	if err := decodeV1Body(r, &request); err != nil {
+		writeV1Error(w, r, err)
+		return
+	}
+	container.lock.Lock()
+	container.CgroupRequest.DryRun = request.DryRun
+	cgroupRequest := container.CgroupRequest
+	container.lock.Unlock()
+	GetContainerManager().store()
+	writeV1(w, r, http.StatusOK, cgroupRequest)
+}
+
+// resetContainerCgroup resets a cgroup of the container to the parent's and audits it.
+func resetContainerCgroup(r *http.Request, container *Container, subSystem string) error {
+	container.lock.Lock()
+	defer container.lock.Unlock()
+	write := CgroupWrite{SubSystem: subSystem, File: "cpu.shares", Old: container.CgroupCurrent.CPU.Shares}
+	if subSystem == config.CpuSetSubSystem {
+		write.File = "cpuset.cpus"
+		write.Old = container.CgroupCurrent.CPUSet.CPUS
+	}
+	err := cgroups.ResetCgroupSubSystem(subSystem, container.Id)
+	if err == nil {
+		write.New, _ = cgroups.GetCgroupValue(subSystem, container.Id, write.File)
+	}
+	auditAPIWrite(r, container.Id, write, err)
+	if err != nil {
+		return err
+	}
+	switch subSystem {
+	case config.CpuSetSubSystem:
+		container.CgroupRequest.CPUSet = CgroupCPUSet{}
+		container.CgroupCurrent.CPUSet.CPUS = write.New
+	case config.CpuSubSystem:
+		container.CgroupRequest.CPU = CgroupCPU{}
+		container.CgroupCurrent.CPU.Shares = write.New
+	}
+	return nil
+}
+
+func restfulV1ProcessGetContainer(w http.ResponseWriter, r *http.Request) {
+	pid := mux.Vars(r)["pid"]
+	if _, err := strconv.ParseInt(pid, 10, 32); err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Wrong process ID '%s'", pid))
+		return
+	}
+	b, err := ioutil.ReadFile(path.Join(procPath, pid, "cgroup"))
+	if err != nil {
+		writeV1Error(w, r, newAPIError(http.StatusNotFound, ErrorNotFound, "The process '%s' does not exist", pid))
+		return
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if (len(fields) != 3) || !strings.Contains(fields[1], config.CpuSetSubSystem) {
+			continue
+		}
+		parent, id := path.Split(fields[2])
+		container := Container{Id: id, Type: path.Base(parent), Path: fields[2]}
+		if fields[2] == "/" {
+			container = Container{Id: "", Type: "", Path: "/"}
+		}
+		writeV1(w, r, http.StatusOK, &container)
+		return
+	}
+	writeV1Error(w, r, newAPIError(http.StatusNotFound, ErrorNotFound, "The process '%s' is not in a cpuset cgroup", pid))
+}
+
+func restfulV1Control(w http.ResponseWriter, r *http.Request) {
+	controlMsg := strings.ToLower(mux.Vars(r)["controlMsg"])
+	switch controlMsg {
+	case "resume":
+		StartMainLoop()
+	case "pause":
+		StopMainLoop()
+	case "dryrun":
+		config.Override("dry_run", true)
+	case "live":
+		config.Override("dry_run", false)
+	case "reload":
+		if err := Reload(); err != nil {
+			writeV1Error(w, r, newAPIError(http.StatusUnprocessableEntity, ErrorInvalidConfig, "%s", err))
+			return
+		}
+	case "exit":
+		writeV1(w, r, http.StatusAccepted, SimpleResult{Result: true, Desc: controlMsg})
+		stopDaemon()
+		return
+	default:
+		writeV1Error(w, r, newAPIError(http.StatusBadRequest, ErrorBadRequest, "Unknown control '%s'", controlMsg))
+		return
+	}
+	writeV1(w, r, http.StatusOK, SimpleResult{Result: true, Desc: controlMsg})
+}
@@ -0,0 +1,289 @@
+package cperfc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cperfc/config"
+	"cperfc/cgroups"
+)
+
+type ScalingPolicy interface {
+	Name() string
+	Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (desired CgroupInfo, reason string)
+}
+
+type ScalingPolicyFactory func() ScalingPolicy
+
+const minShares = 2
+const maxShares = 262144
+const defaultShares = 1024
+
+var scalingPolicies = make(map[string]ScalingPolicyFactory)
+var machineCores = 0
+var clock = time.Now
+
+func init() {
+}
+
+func RegisterScalingPolicy(name string, factory ScalingPolicyFactory) {
+	scalingPolicies[strings.ToLower(name)] = factory
+}
+
+func NewScalingPolicy(name string) (ScalingPolicy, error) {
+	if len(name) == 0 {
+		name = config.DefaultPolicy
+	}
+	factory, exist := scalingPolicies[strings.ToLower(name)]
+	if !exist {
+		return nil, fmt.Errorf("Unknown scaling policy '%s'", name)
+	}
+	return factory(), nil
+}
+
+func IsScalingPolicy(name string) bool {
+	_, err := NewScalingPolicy(name)
+	return err == nil
+}
+
+func GetScalingPolicyNames() []string {
+	var names []string
+
+	for name := range scalingPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (self *Container)GetScalingPolicy() (ScalingPolicy, error) {
+	name := self.CgroupRequest.Policy
+	if len(name) == 0 {
+		name = config.GetDefaultPolicy(self.Type)
+	}
+	if (self.policy == nil) || (self.policy.Name() != strings.ToLower(name)) {
+		policy, err := NewScalingPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		self.policy = policy
+	}
+	return self.policy, nil
+}
+
+// scaleContainer is called with the container locked.
+func scaleContainer(container *Container) {
+	policy, err := container.GetScalingPolicy()
+	if err != nil {
+		return
+	}
+	if container.History == nil {
+		return
+	}
+	if consumer, ok := policy.(SLOConsumer); ok {
+		consumer.SetSLOReport(container.SLOReport)
+	}
+	samples := container.History.GetAll()
+	desired, reason := policy.Decide(samples, container.CgroupCurrent, container.CgroupRequest)
+	if forecaster, ok := policy.(Forecaster); ok {
+		report := forecaster.GetForecastReport()
+		container.Forecast = &report
+	} else {
+		container.Forecast = nil
+	}
+	now := clock()
+	notifyCeiling(container, samples, desired, now)
+	usage, _ := recentUsage(samples, usageWindow())
+	action := ScalingAction{Policy: policy.Name(), Reason: reason, Usage: usage, DryRun: config.DryRun || container.CgroupRequest.DryRun}
+	if (len(desired.CPUSet.CPUS) > 0) && (desired.CPUSet.CPUS != container.CgroupCurrent.CPUSet.CPUS) {
+		write := CgroupWrite{SubSystem: config.CpuSetSubSystem, File: "cpuset.cpus", Old: container.CgroupCurrent.CPUSet.CPUS, New: desired.CPUSet.CPUS}
+		if now.Before(container.CgroupCurrent.CPUSet.Cooltime) {
+			action.Cooldown = append(action.Cooldown, write)
+		} else {
+			action.Writes = append(action.Writes, write)
+		}
+	}
+	if (len(desired.CPU.Shares) > 0) && (desired.CPU.Shares != container.CgroupCurrent.CPU.Shares) {
+		write := CgroupWrite{SubSystem: config.CpuSubSystem, File: "cpu.shares", Old: container.CgroupCurrent.CPU.Shares, New: desired.CPU.Shares}
+		if now.Before(container.CgroupCurrent.CPU.Cooltime) {
+			action.Cooldown = append(action.Cooldown, write)
+		} else {
+			action.Writes = append(action.Writes, write)
+		}
+	}
+	if len(action.Writes) > 0 {
+		if action.DryRun {
+			GetRecommendations().Add(Recommendation{Timestamp: now, Id: container.Id, Policy: policy.Name(), Reason: reason, Usage: usage, Writes: action.Writes})
+		} else {
+			var errs []string
+			for _, write := range action.Writes {
+				if err := applyCgroupWrite(container, write, &action, now); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %s", write.File, err))
+				}
+			}
+			action.Error = strings.Join(errs, ", ")
+		}
+	}
+	GetEventBus().Publish(Event{Type: EventDecisionMade, Timestamp: now, Id: container.Id, Container: container, Action: &action})
+}
+
+func applyCgroupWrite(container *Container, write CgroupWrite, action *ScalingAction, now time.Time) error {
+	err := cgroups.SetCgroupValue(write.SubSystem, container.Id, write.File, write.New)
+	event := Event{Type: EventCgroupWritten, Timestamp: now, Id: container.Id, Container: container, Write: &write, Action: action}
+	if err != nil {
+		event.Error = fmt.Sprint(err)
+		GetEventBus().Publish(event)
+		return err
+	}
+	cooltime := now.Add(time.Duration(config.ScalingCooldown) * time.Second)
+	switch write.SubSystem {
+	case config.CpuSetSubSystem:
+		container.CgroupCurrent.CPUSet.CPUS = write.New
+		container.CgroupCurrent.CPUSet.Cooltime = cooltime
+		container.masks.Change(now, write.Old, write.New)
+	case config.CpuSubSystem:
+		container.CgroupCurrent.CPU.Shares = write.New
+		container.CgroupCurrent.CPU.Cooltime = cooltime
+	}
+	GetEventBus().Publish(event)
+	return nil
+}
+
+func notifyCeiling(container *Container, samples []Sample, desired CgroupInfo, now time.Time) {
+	ceiling := ""
+	request := container.CgroupRequest.CPUSet
+	usage, ok := recentUsage(samples, usageWindow())
+	cores := countCores(desired.CPUSet.CPUS)
+	if ok && (request.ThreshMax > 0) && (usage > float64(request.ThreshMax)) {
+		if (request.MaxCores > 0) && (cores >= request.MaxCores) {
+			ceiling = WebhookMaxCores
+		} else if (availableCores() > 0) && (cores >= availableCores()) {
+			ceiling = WebhookPoolExhausted
+		}
+	}
+	if (len(ceiling) > 0) && (ceiling != container.ceiling) {
+		message := fmt.Sprintf("usage %.2f%% of %d cores is over %d%%, but no more cores(%s)", usage, cores, request.ThreshMax, ceiling)
+		GetEventBus().Publish(Event{Type: EventCeilingReached, Timestamp: now, Id: container.Id, Container: container, Reason: ceiling, Message: message})
+	}
+	container.ceiling = ceiling
+}
+
+func recentUsage(samples []Sample, window time.Duration) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	last := samples[len(samples) - 1].Timestamp
+	usage := 0.0
+	count := 0
+	for i := len(samples) - 1; i >= 0; i-- {
+		if last.Sub(samples[i].Timestamp) > window {
+			break
+		}
+		usage += samples[i].CPUUsage
+		count++
+	}
+	return usage / float64(count), true
+}
+
+func usageWindow() time.Duration {
+	return time.Duration(config.MainLoopInterval) * time.Second
+}
+
+func reservedCores() map[int]bool {
+	reserved := make(map[int]bool)
+	if len(config.ReservedCores) > 0 {
+		for _, core := range cgroups.DecodeListFormat(config.ReservedCores) {
+			reserved[core] = true
+		}
+	}
+	return reserved
+}
+
+func availableCores() int {
+	cores := machineCores
+	for core := range reservedCores() {
+		if core < machineCores {
+			cores--
+		}
+	}
+	return cores
+}
+
+func clampCores(request CgroupCPUSet, cores int) int {
+	maxCores := availableCores()
+	if (request.MaxCores > 0) && ((maxCores == 0) || (request.MaxCores < maxCores)) {
+		maxCores = request.MaxCores
+	}
+	minCores := request.MinCores
+	if minCores < 1 {
+		minCores = 1
+	}
+	if (maxCores > 0) && (cores > maxCores) {
+		cores = maxCores
+	}
+	if cores < minCores {
+		cores = minCores
+	}
+	return cores
+}
+
+func resizeCPUSet(cpus string, cores int) string {
+	var list []int
+
+	reserved := reservedCores()
+	if len(strings.TrimSpace(cpus)) > 0 {
+		for _, core := range cgroups.DecodeListFormat(strings.TrimSpace(cpus)) {
+			if !reserved[core] {
+				list = append(list, core)
+			}
+		}
+	}
+	sort.Ints(list)
+	if cores < len(list) {
+		list = list[:cores]
+	}
+	for core := 0; (len(list) < cores) && (core < machineCores); core++ {
+		if reserved[core] {
+			continue
+		}
+		used := false
+		for _, number := range list {
+			if number == core {
+				used = true
+				break
+			}
+		}
+		if !used {
+			list = append(list, core)
+		}
+	}
+	if len(list) == 0 {
+		return cpus
+	}
+	sort.Ints(list)
+	return cgroups.EncodeListFormat(list)
+}
+
+func countCores(cpus string) int {
+	if len(strings.TrimSpace(cpus)) == 0 {
+		return 0
+	}
+	return len(cgroups.DecodeListFormat(strings.TrimSpace(cpus)))
+}
+
+func parseShares(shares string) int {
+	value, err := strconv.Atoi(strings.TrimSpace(shares))
+	if err != nil || value <= 0 {
+		return defaultShares
+	}
+	return value
+}
+
+func clampShares(shares int) string {
+	shares = int(math.Max(minShares, math.Min(maxShares, float64(shares))))
+	return strconv.Itoa(shares)
+}
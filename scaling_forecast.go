@@ -0,0 +1,142 @@
+package cperfc
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type Forecast struct {
+	Made			time.Time		`json:"made"`
+	Target			time.Time		`json:"target"`
+	Predicted		float64			`json:"predicted"`
+	Lower			float64			`json:"lower"`
+	Upper			float64			`json:"upper"`
+	Actual			float64			`json:"actual"`
+	Evaluated		bool			`json:"evaluated"`
+}
+
+type ForecastReport struct {
+	Current			Forecast		`json:"current"`
+	Previous		Forecast		`json:"previous"`
+	Evaluated		int				`json:"evaluated"`
+	MeanAbsError	float64			`json:"mean_abs_error"`
+	Hits			int				`json:"hits"`			// actual was inside [lower, upper]
+}
+
+type Forecaster interface {
+	GetForecastReport() ForecastReport
+}
+
+type predictivePolicy struct {
+	Alpha			float64
+	Beta			float64
+	Z				float64
+	Window			int
+	report			ForecastReport
+	pending			[]Forecast		// to be evaluated by the first sample at or after the target, in order
+	absErrorSum		float64
+}
+
+const minForecastSamples = 3
+
+func init() {
+	RegisterScalingPolicy("predictive", func() ScalingPolicy {
+		return &predictivePolicy{Alpha: 0.5, Beta: 0.3, Z: 1.645, Window: 60}
+	})
+}
+
+func HoltForecast(values []float64, alpha float64, beta float64, steps float64) (forecast float64, sigma float64) {
+	level := values[0]
+	trend := values[1] - values[0]
+	squareSum := 0.0
+	for _, value := range values[1:] {
+		residual := value - (level + trend)
+		squareSum += residual * residual
+		prevLevel := level
+		level = alpha * value + (1 - alpha) * (level + trend)
+		trend = beta * (level - prevLevel) + (1 - beta) * trend
+	}
+	return level + steps * trend, math.Sqrt(squareSum / float64(len(values) - 1))
+}
+
+func (self *predictivePolicy)Name() string {
+	return "predictive"
+}
+
+func (self *predictivePolicy)GetForecastReport() ForecastReport {
+	return self.report
+}
+
+func (self *predictivePolicy)evaluate(samples []Sample) {
+	for len(self.pending) > 0 {
+		forecast := self.pending[0]
+		i := len(samples)
+		for (i > 0) && !samples[i - 1].Timestamp.Before(forecast.Target) {
+			i--
+		}
+		if i == len(samples) {
+			return
+		}
+		actual := samples[i].CPUUsage
+		forecast.Actual = actual
+		forecast.Evaluated = true
+		self.report.Previous = forecast
+		self.report.Evaluated++
+		self.absErrorSum += math.Abs(actual - forecast.Predicted)
+		self.report.MeanAbsError = self.absErrorSum / float64(self.report.Evaluated)
+		if (actual >= forecast.Lower) && (actual <= forecast.Upper) {
+			self.report.Hits++
+		}
+		self.pending = self.pending[1:]
+	}
+}
+
+func (self *predictivePolicy)Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (CgroupInfo, string) {
+	desired := current
+	self.evaluate(samples)
+	if len(samples) > self.Window {
+		samples = samples[len(samples) - self.Window:]
+	}
+	if len(samples) < minForecastSamples {
+		return current, "not enough samples to forecast"
+	}
+	last := samples[len(samples) - 1]
+	if !last.Timestamp.After(self.report.Current.Made) {
+		return current, "no new samples"
+	}
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = sample.CPUUsage
+	}
+	interval := last.Timestamp.Sub(samples[0].Timestamp) / time.Duration(len(samples) - 1)
+	if interval <= 0 {
+		return current, "no sample interval"
+	}
+	horizon := usageWindow()
+	steps := float64(horizon) / float64(interval)
+	predicted, sigma := HoltForecast(values, self.Alpha, self.Beta, steps)
+	bound := self.Z * sigma * math.Sqrt(steps)
+	self.report.Current = Forecast{
+		Made: last.Timestamp,
+		Target: last.Timestamp.Add(horizon),
+		Predicted: math.Max(0, predicted),
+		Lower: math.Max(0, predicted - bound),
+		Upper: predicted + bound,
+	}
+	self.pending = append(self.pending, self.report.Current)
+
+	cores := countCores(current.CPUSet.CPUS)
+	if (request.CPUSet.ThreshMax <= 0) || (cores == 0) {
+		return current, "no thresholds requested"
+	}
+	forecast := self.report.Current
+	target := cores
+	setpoint := float64(request.CPUSet.ThreshMin + request.CPUSet.ThreshMax) / 2
+	if (forecast.Upper > float64(request.CPUSet.ThreshMax)) || (forecast.Upper < float64(request.CPUSet.ThreshMin)) {
+		target = int(math.Ceil(float64(cores) * forecast.Upper / setpoint))
+	}
+	target = clampCores(request.CPUSet, target)
+	desired.CPUSet.CPUS = resizeCPUSet(current.CPUSet.CPUS, target)
+	return desired, fmt.Sprintf("predicted %.2f%% [%.2f, %.2f] of %d cores at %s, setpoint %.1f%% -> %d cores", forecast.Predicted, forecast.Lower, forecast.Upper, cores, forecast.Target.Format(time.Stamp), setpoint, target)
+}
@@ -0,0 +1,33 @@
+package cperfc
+
+import (
+	"testing"
+
+	"cperfc/config"
+)
+
+func TestForecastEvaluatedAtTarget(t *testing.T) {
+	defer func(interval int) { config.MainLoopInterval = interval }(config.MainLoopInterval)
+	config.MainLoopInterval = 10
+	policy := &predictivePolicy{Alpha: 0.5, Beta: 0.3, Z: 1.645, Window: 60}
+	samples := []Sample{{Timestamp: at(0), CPUUsage: 50}, {Timestamp: at(10), CPUUsage: 50}, {Timestamp: at(20), CPUUsage: 50}}
+	policy.Decide(samples, CgroupInfo{}, CgroupInfo{})
+	if target := policy.GetForecastReport().Current.Target; !target.Equal(at(30)) {
+		t.Fatalf("expected the forecast for 30s, got %s", target)
+	}
+	// a sample before the target does not score it
+	samples = append(samples, Sample{Timestamp: at(29), CPUUsage: 90})
+	policy.Decide(samples, CgroupInfo{}, CgroupInfo{})
+	if report := policy.GetForecastReport(); report.Evaluated != 0 {
+		t.Fatalf("expected no forecast evaluated before the target, got %+v", report.Previous)
+	}
+	samples = append(samples, Sample{Timestamp: at(31), CPUUsage: 40})
+	policy.Decide(samples, CgroupInfo{}, CgroupInfo{})
+	report := policy.GetForecastReport()
+	if (report.Evaluated != 1) || !report.Previous.Target.Equal(at(30)) || (report.Previous.Actual != 40) {
+		t.Errorf("expected the forecast for 30s to be scored by the sample at 31s, got %d %+v", report.Evaluated, report.Previous)
+	}
+	if report.MeanAbsError != 10 {
+		t.Errorf("expected the error 10 of the predicted 50, got %f", report.MeanAbsError)
+	}
+}
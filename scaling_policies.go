@@ -0,0 +1,155 @@
+package cperfc
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type nonePolicy struct {
+}
+
+type thresholdPolicy struct {
+}
+
+type proportionalPolicy struct {
+}
+
+type pidPolicy struct {
+	Kp				float64
+	Ki				float64
+	Kd				float64
+	integral		float64
+	prevError		float64
+	prevTime		time.Time
+}
+
+const pidIntegralLimit = 2.0
+
+func init() {
+	RegisterScalingPolicy("none", func() ScalingPolicy { return &nonePolicy{} })
+	RegisterScalingPolicy("threshold", func() ScalingPolicy { return &thresholdPolicy{} })
+	RegisterScalingPolicy("proportional", func() ScalingPolicy { return &proportionalPolicy{} })
+	RegisterScalingPolicy("pid", func() ScalingPolicy { return &pidPolicy{Kp: 1.0, Ki: 0.05, Kd: 0.1} })
+}
+
+func (self *nonePolicy)Name() string {
+	return "none"
+}
+
+func (self *nonePolicy)Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (CgroupInfo, string) {
+	return current, "scaling disabled"
+}
+
+func (self *thresholdPolicy)Name() string {
+	return "threshold"
+}
+
+func (self *thresholdPolicy)Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (CgroupInfo, string) {
+	var reasons []string
+
+	desired := current
+	usage, ok := recentUsage(samples, usageWindow())
+	if !ok {
+		return current, "no samples"
+	}
+	cores := countCores(current.CPUSet.CPUS)
+	if (request.CPUSet.ThreshMax > 0) && (cores > 0) {
+		target := cores
+		if usage > float64(request.CPUSet.ThreshMax) {
+			target++
+		} else if usage < float64(request.CPUSet.ThreshMin) {
+			target--
+		}
+		target = clampCores(request.CPUSet, target)
+		desired.CPUSet.CPUS = resizeCPUSet(current.CPUSet.CPUS, target)
+		reasons = append(reasons, fmt.Sprintf("usage %.2f%% of %d cores in [%d, %d]%% -> %d cores", usage, cores, request.CPUSet.ThreshMin, request.CPUSet.ThreshMax, target))
+	}
+	if request.CPU.ThreshMax > 0 {
+		shares := parseShares(current.CPU.Shares)
+		if usage > float64(request.CPU.ThreshMax) {
+			shares *= 2
+		} else if usage < float64(request.CPU.ThreshMin) {
+			shares /= 2
+		}
+		desired.CPU.Shares = clampShares(shares)
+		reasons = append(reasons, fmt.Sprintf("usage %.2f%% in [%d, %d]%% -> %s shares", usage, request.CPU.ThreshMin, request.CPU.ThreshMax, desired.CPU.Shares))
+	}
+	if len(reasons) == 0 {
+		return current, "no thresholds requested"
+	}
+	return desired, strings.Join(reasons, ", ")
+}
+
+func (self *proportionalPolicy)Name() string {
+	return "proportional"
+}
+
+func (self *proportionalPolicy)Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (CgroupInfo, string) {
+	var reasons []string
+
+	desired := current
+	usage, ok := recentUsage(samples, usageWindow())
+	if !ok {
+		return current, "no samples"
+	}
+	cores := countCores(current.CPUSet.CPUS)
+	if (request.CPUSet.ThreshMax > 0) && (cores > 0) {
+		target := cores
+		setpoint := float64(request.CPUSet.ThreshMin + request.CPUSet.ThreshMax) / 2
+		if (usage > float64(request.CPUSet.ThreshMax)) || (usage < float64(request.CPUSet.ThreshMin)) {
+			target = int(math.Ceil(float64(cores) * usage / setpoint))
+		}
+		target = clampCores(request.CPUSet, target)
+		desired.CPUSet.CPUS = resizeCPUSet(current.CPUSet.CPUS, target)
+		reasons = append(reasons, fmt.Sprintf("usage %.2f%% of %d cores, setpoint %.1f%% -> %d cores", usage, cores, setpoint, target))
+	}
+	if request.CPU.ThreshMax > 0 {
+		shares := parseShares(current.CPU.Shares)
+		setpoint := float64(request.CPU.ThreshMin + request.CPU.ThreshMax) / 2
+		if (usage > float64(request.CPU.ThreshMax)) || (usage < float64(request.CPU.ThreshMin)) {
+			shares = int(float64(shares) * usage / setpoint)
+		}
+		desired.CPU.Shares = clampShares(shares)
+		reasons = append(reasons, fmt.Sprintf("usage %.2f%%, setpoint %.1f%% -> %s shares", usage, setpoint, desired.CPU.Shares))
+	}
+	if len(reasons) == 0 {
+		return current, "no thresholds requested"
+	}
+	return desired, strings.Join(reasons, ", ")
+}
+
+func (self *pidPolicy)Name() string {
+	return "pid"
+}
+
+func (self *pidPolicy)Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (CgroupInfo, string) {
+	desired := current
+	usage, ok := recentUsage(samples, usageWindow())
+	if !ok {
+		return current, "no samples"
+	}
+	cores := countCores(current.CPUSet.CPUS)
+	if (request.CPUSet.ThreshMax <= 0) || (cores == 0) {
+		return current, "no setpoint requested"
+	}
+	now := samples[len(samples) - 1].Timestamp
+	setpoint := float64(request.CPUSet.ThreshMin + request.CPUSet.ThreshMax) / 2
+	e := (usage - setpoint) / 100
+	derivative := 0.0
+	if !self.prevTime.IsZero() {
+		dt := now.Sub(self.prevTime).Seconds()
+		if dt <= 0 {
+			return current, "no new samples"
+		}
+		self.integral = math.Max(-pidIntegralLimit, math.Min(pidIntegralLimit, self.integral + e * dt))
+		derivative = (e - self.prevError) / dt
+	}
+	self.prevError = e
+	self.prevTime = now
+	output := self.Kp * e + self.Ki * self.integral + self.Kd * derivative
+	target := clampCores(request.CPUSet, int(math.Floor(float64(cores) * (1 + output) + 0.5)))
+	desired.CPUSet.CPUS = resizeCPUSet(current.CPUSet.CPUS, target)
+	return desired, fmt.Sprintf("usage %.2f%% of %d cores, setpoint %.1f%%, output %+.3f -> %d cores", usage, cores, setpoint, output, target)
+}
@@ -0,0 +1,103 @@
+package cperfc
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"cperfc/config"
+)
+
+type CgroupSLO struct {
+	Metric			string			`json:"metric"`			// e.g. p99_latency_ms, queue_depth
+	Target			float64			`json:"target"`
+	Lower			float64			`json:"lower"`			// scale down below this value, default 'target / 2'
+	StaleAfter		int				`json:"stale_after"`	// seconds, default 3 monitoring intervals
+}
+
+type SLOReport struct {
+	Metric			string			`json:"metric"`
+	Value			float64			`json:"value"`
+	Timestamp		time.Time		`json:"timestamp"`
+}
+
+type SLOConsumer interface {
+	SetSLOReport(report *SLOReport)
+}
+
+type sloPolicy struct {
+	fallback		thresholdPolicy
+	report			*SLOReport
+}
+
+func init() {
+	RegisterScalingPolicy("slo", func() ScalingPolicy { return &sloPolicy{} })
+}
+
+func (self *CgroupSLO)staleAfter() time.Duration {
+	if self.StaleAfter > 0 {
+		return time.Duration(self.StaleAfter) * time.Second
+	}
+	return 3 * time.Duration(config.MainLoopInterval) * time.Second
+}
+
+func (self *CgroupSLO)lower() float64 {
+	if self.Lower > 0 {
+		return self.Lower
+	}
+	return self.Target / 2
+}
+
+func (self *sloPolicy)Name() string {
+	return "slo"
+}
+
+func (self *sloPolicy)SetSLOReport(report *SLOReport) {
+	self.report = report
+}
+
+func (self *sloPolicy)Decide(samples []Sample, current CgroupInfo, request CgroupInfo) (CgroupInfo, string) {
+	slo := request.SLO
+	if slo.Target <= 0 {
+		desired, reason := self.fallback.Decide(samples, current, request)
+		return desired, "no SLO target, " + reason
+	}
+	if (self.report == nil) || ((len(slo.Metric) > 0) && (self.report.Metric != slo.Metric)) {
+		desired, reason := self.fallback.Decide(samples, current, request)
+		return desired, fmt.Sprintf("no '%s' report, %s", slo.Metric, reason)
+	}
+	if age := clock().Sub(self.report.Timestamp); age > slo.staleAfter() {
+		desired, reason := self.fallback.Decide(samples, current, request)
+		return desired, fmt.Sprintf("'%s' report is stale(%s), %s", self.report.Metric, age.Truncate(time.Second), reason)
+	}
+
+	desired := current
+	value := self.report.Value
+	direction := 0
+	if value > slo.Target {
+		direction = 1
+	} else if value < slo.lower() {
+		direction = -1
+	}
+	cores := countCores(current.CPUSet.CPUS)
+	target := cores
+	if cores > 0 {
+		if direction > 0 {
+			target = int(math.Ceil(float64(cores) * math.Min(value / slo.Target, 2)))
+		} else if direction < 0 {
+			target = cores - 1
+		}
+		target = clampCores(request.CPUSet, target)
+		desired.CPUSet.CPUS = resizeCPUSet(current.CPUSet.CPUS, target)
+	}
+	if request.CPU.ThreshMax > 0 {
+		shares := parseShares(current.CPU.Shares)
+		if direction > 0 {
+			shares *= 2
+		} else if direction < 0 {
+			shares /= 2
+		}
+		desired.CPU.Shares = clampShares(shares)
+	}
+	return desired, fmt.Sprintf("%s %.2f, target %.2f, lower %.2f -> %d cores", self.report.Metric, value, slo.Target, slo.lower(), target)
+}
@@ -0,0 +1,203 @@
+package cperfc
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"cperfc/config"
+)
+
+type SimulationConfig struct {
+	TraceFile		string
+	MachineCores	int				// 0 to use the recorded core count
+	Interval		time.Duration	// virtual monitoring interval, 0 to use 'config.MainLoopInterval'
+	Policy			string			// overrides the recorded policies if not empty
+}
+
+type SimulationAction struct {
+	Timestamp		time.Time		`json:"timestamp"`
+	Id				string			`json:"id"`
+	Policy			string			`json:"policy"`
+	Reason			string			`json:"reason"`
+	Writes			[]CgroupWrite	`json:"writes"`
+}
+
+type SimulationContainerReport struct {
+	Id				string			`json:"id"`
+	Policy			string			`json:"policy"`
+	Samples			int				`json:"samples"`
+	Actions			int				`json:"actions"`
+	OverThreshold	float64			`json:"over_threshold"`		// seconds over 'thresh_max'
+	CoreSeconds		float64			`json:"core_seconds"`			// allotted cores * seconds
+	UsedCoreSeconds	float64			`json:"used_core_seconds"`
+	UnmetCoreSeconds	float64		`json:"unmet_core_seconds"`	// demand beyond the allotted cores
+	MinCores		int				`json:"min_cores"`
+	MaxCores		int				`json:"max_cores"`
+}
+
+type SimulationReport struct {
+	MachineCores	int				`json:"machine_cores"`
+	Start			time.Time		`json:"start"`
+	End				time.Time		`json:"end"`
+	Actions			[]SimulationAction	`json:"actions"`
+	Containers		map[string]*SimulationContainerReport	`json:"containers"`
+}
+
+type simulatedContainer struct {
+	container		Container
+	report			*SimulationContainerReport
+	samples			[]TraceRecord
+	next			int
+	prevTime		time.Time
+}
+
+func init() {
+}
+
+func Simulate(simulation SimulationConfig) (*SimulationReport, error) {
+	records, err := ReadTrace(simulation.TraceFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("Empty trace")
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time < records[j].Time })
+
+	report := &SimulationReport{MachineCores: simulation.MachineCores, Containers: make(map[string]*SimulationContainerReport)}
+	containers := make(map[string]*simulatedContainer)
+	var ids []string
+	for _, record := range records {
+		if report.MachineCores == 0 {
+			report.MachineCores = record.MachineCores
+		}
+		simulated, exist := containers[record.Id]
+		if !exist {
+			simulated = &simulatedContainer{container: Container{Id: record.Id, History: NewSampleHistory(config.HistoryLength)}}
+			simulated.report = &SimulationContainerReport{Id: record.Id}
+			containers[record.Id] = simulated
+			report.Containers[record.Id] = simulated.report
+			ids = append(ids, record.Id)
+		}
+		simulated.samples = append(simulated.samples, record)
+	}
+	if report.MachineCores == 0 {
+		return nil, errors.New("Unknown machine core count")
+	}
+	interval := simulation.Interval
+	if interval <= 0 {
+		interval = time.Duration(config.MainLoopInterval) * time.Second
+	}
+
+	savedClock, savedMachineCores := clock, machineCores
+	defer func() {
+		clock, machineCores = savedClock, savedMachineCores
+	}()
+	machineCores = report.MachineCores
+	report.Start = time.Unix(0, records[0].Time)
+	report.End = time.Unix(0, records[len(records) - 1].Time)
+	for now := report.Start.Add(interval); ; now = now.Add(interval) {
+		clock = func() time.Time { return now }
+		for _, id := range ids {
+			simulated := containers[id]
+			simulated.feed(now, simulation.Policy)
+			if simulated.container.History.Len() == 0 {
+				continue
+			}
+			if action, ok := simulated.scale(now); ok {
+				report.Actions = append(report.Actions, action)
+			}
+		}
+		if now.After(report.End) {
+			break
+		}
+	}
+	return report, nil
+}
+
+func (self *simulatedContainer)feed(now time.Time, policy string) {
+	container := &self.container
+	for ; self.next < len(self.samples); self.next++ {
+		record := self.samples[self.next]
+		timestamp := time.Unix(0, record.Time)
+		if timestamp.After(now) {
+			return
+		}
+		if record.Request != nil {
+			container.CgroupRequest = *record.Request
+			if len(policy) > 0 {
+				container.CgroupRequest.Policy = policy
+			}
+			continue
+		}
+		if len(container.CgroupCurrent.CPUSet.CPUS) == 0 {
+			container.CgroupCurrent.CPUSet.CPUS = resizeCPUSet(record.CPUS, clampCores(CgroupCPUSet{}, countCores(record.CPUS)))
+			container.CgroupCurrent.CPU.Shares = strconv.FormatUint(record.Shares, 10)
+		}
+		cores := countCores(container.CgroupCurrent.CPUSet.CPUS)
+		demand := record.Usage * float64(record.Cores) / 100
+		usage := 0.0
+		if cores > 0 {
+			usage = math.Min(100, demand / float64(cores) * 100)
+		}
+		sample := record.GetSample()
+		sample.CPUUsage = usage
+		sample.Cores = cores
+		sample.CPUS = container.CgroupCurrent.CPUSet.CPUS
+		sample.Shares, _ = strconv.ParseUint(container.CgroupCurrent.CPU.Shares, 10, 64)
+		container.History.Add(sample)
+
+		report := self.report
+		report.Samples++
+		if (report.MinCores == 0) || (cores < report.MinCores) {
+			report.MinCores = cores
+		}
+		if cores > report.MaxCores {
+			report.MaxCores = cores
+		}
+		if !self.prevTime.IsZero() {
+			dt := timestamp.Sub(self.prevTime).Seconds()
+			report.CoreSeconds += float64(cores) * dt
+			report.UsedCoreSeconds += math.Min(demand, float64(cores)) * dt
+			report.UnmetCoreSeconds += math.Max(0, demand - float64(cores)) * dt
+			if (container.CgroupRequest.CPUSet.ThreshMax > 0) && (usage > float64(container.CgroupRequest.CPUSet.ThreshMax)) {
+				report.OverThreshold += dt
+			}
+		}
+		self.prevTime = timestamp
+	}
+}
+
+func (self *simulatedContainer)scale(now time.Time) (SimulationAction, bool) {
+	var writes []CgroupWrite
+
+	container := &self.container
+	policy, err := container.GetScalingPolicy()
+	if err != nil {
+		return SimulationAction{}, false
+	}
+	self.report.Policy = policy.Name()
+	if consumer, ok := policy.(SLOConsumer); ok {
+		consumer.SetSLOReport(nil)
+	}
+	desired, reason := policy.Decide(container.History.GetAll(), container.CgroupCurrent, container.CgroupRequest)
+	cooltime := now.Add(time.Duration(config.ScalingCooldown) * time.Second)
+	if (len(desired.CPUSet.CPUS) > 0) && (desired.CPUSet.CPUS != container.CgroupCurrent.CPUSet.CPUS) && !now.Before(container.CgroupCurrent.CPUSet.Cooltime) {
+		writes = append(writes, CgroupWrite{SubSystem: config.CpuSetSubSystem, File: "cpuset.cpus", Old: container.CgroupCurrent.CPUSet.CPUS, New: desired.CPUSet.CPUS})
+		container.CgroupCurrent.CPUSet.CPUS = desired.CPUSet.CPUS
+		container.CgroupCurrent.CPUSet.Cooltime = cooltime
+	}
+	if (len(desired.CPU.Shares) > 0) && (desired.CPU.Shares != container.CgroupCurrent.CPU.Shares) && !now.Before(container.CgroupCurrent.CPU.Cooltime) {
+		writes = append(writes, CgroupWrite{SubSystem: config.CpuSubSystem, File: "cpu.shares", Old: container.CgroupCurrent.CPU.Shares, New: desired.CPU.Shares})
+		container.CgroupCurrent.CPU.Shares = desired.CPU.Shares
+		container.CgroupCurrent.CPU.Cooltime = cooltime
+	}
+	if len(writes) == 0 {
+		return SimulationAction{}, false
+	}
+	self.report.Actions++
+	return SimulationAction{Timestamp: now, Id: container.Id, Policy: policy.Name(), Reason: reason, Writes: writes}, true
+}
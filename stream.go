@@ -0,0 +1,158 @@
+package cperfc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cperfc/log"
+)
+
+type ScalingAction struct {
+	Policy			string			`json:"policy"`
+	Reason			string			`json:"reason"`
+	Usage			float64			`json:"usage"`			// recent usage the policy decided on
+	Writes			[]CgroupWrite	`json:"writes"`
+	DryRun			bool			`json:"dry_run"`
+	Cooldown		[]CgroupWrite	`json:"cooldown,omitempty"`	// writes held back until cooltime
+	Error			string			`json:"error,omitempty"`
+}
+
+type StreamEvent struct {
+	Type			string			`json:"type"`			// {sample, action}
+	Id				string			`json:"id"`
+	Timestamp		time.Time		`json:"timestamp"`
+	Sample			*Sample			`json:"sample,omitempty"`
+	Action			*ScalingAction	`json:"action,omitempty"`
+}
+
+type StreamSubscriber struct {
+	ids				map[string]bool
+	types			map[string]bool
+	events			chan StreamEvent
+	dropped			int64
+}
+
+type StreamHub struct {
+	lock			sync.RWMutex
+	subscribers		map[*StreamSubscriber]bool
+}
+
+const streamBufferSize = 256
+const streamHeartbeat = 15 * time.Second
+
+var streamHub = StreamHub{subscribers: make(map[*StreamSubscriber]bool)}
+
+func init() {
+}
+
+func GetStreamHub() *StreamHub {
+	return &streamHub
+}
+
+func subscribeStream() {
+	GetEventBus().Subscribe("stream", []string{EventSampleCollected, EventDecisionMade}, func(event Event) {
+		switch event.Type {
+		case EventSampleCollected:
+			GetStreamHub().Publish(StreamEvent{Type: "sample", Id: event.Id, Timestamp: event.Timestamp, Sample: event.Sample})
+		case EventDecisionMade:
+			if len(event.Action.Writes) > 0 {
+				GetStreamHub().Publish(StreamEvent{Type: "action", Id: event.Id, Timestamp: event.Timestamp, Action: event.Action})
+			}
+		}
+	})
+}
+
+func (self *StreamHub)Subscribe(ids []string, types []string) *StreamSubscriber {
+	subscriber := &StreamSubscriber{ids: make(map[string]bool), types: make(map[string]bool), events: make(chan StreamEvent, streamBufferSize)}
+	for _, id := range ids {
+		subscriber.ids[id] = true
+	}
+	for _, eventType := range types {
+		subscriber.types[eventType] = true
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.subscribers[subscriber] = true
+	return subscriber
+}
+
+func (self *StreamHub)Unsubscribe(subscriber *StreamSubscriber) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.subscribers, subscriber)
+}
+
+func (self *StreamHub)Publish(event StreamEvent) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for subscriber := range self.subscribers {
+		if (len(subscriber.ids) > 0) && !subscriber.ids[event.Id] {
+			continue
+		}
+		if (len(subscriber.types) > 0) && !subscriber.types[event.Type] {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			atomic.AddInt64(&subscriber.dropped, 1)
+		}
+	}
+}
+
+func (self *StreamHub)Count() int {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return len(self.subscribers)
+}
+
+func restfulStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	hub := GetStreamHub()
+	subscriber := hub.Subscribe(query["cid"], query["type"])
+	defer hub.Unsubscribe(subscriber)
+	log.Printf("Stream API: subscribed %s(%d subscribers)", r.RemoteAddr, hub.Count())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <- subscriber.events:
+			if dropped := atomic.SwapInt64(&subscriber.dropped, 0); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped)
+			}
+			b, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b); err != nil {
+				log.Printf("Stream API: %s is gone", r.RemoteAddr)
+				return
+			}
+			flusher.Flush()
+		case <- heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <- r.Context().Done():
+			log.Printf("Stream API: unsubscribed %s", r.RemoteAddr)
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cperfc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cperfc/log"
+)
+
+type TraceRecord struct {
+	Time				int64			`json:"t"`				// unix nano
+	Id					string			`json:"id"`
+	Usage				float64			`json:"u,omitempty"`
+	Cores				int				`json:"c,omitempty"`
+	CPUS				string			`json:"m,omitempty"`
+	Shares				uint64			`json:"s,omitempty"`
+	ThrottledPeriods	uint64			`json:"tp,omitempty"`
+	ThrottledTime		uint64			`json:"tt,omitempty"`
+	MachineCores		int				`json:"n,omitempty"`
+	Request				*CgroupInfo		`json:"r,omitempty"`	// set only for request records
+}
+
+type TraceRecorder struct {
+	lock			sync.Mutex
+	file			*os.File
+	gzipWriter		*gzip.Writer
+	writer			*bufio.Writer
+	encoder			*json.Encoder
+	requests		map[string]string
+	interval		time.Duration	// to flush the records after the first unflushed one
+	timer			*time.Timer		// nil if all records are flushed
+	closed			bool
+}
+
+// traceFlushInterval bounds the records lost by a crash, without flushing gzip for every sample.
+const traceFlushInterval = 10 * time.Second
+
+var traceRecorder *TraceRecorder
+
+func init() {
+}
+
+func subscribeTrace() {
+	GetEventBus().SubscribeSync("trace", []string{EventSampleCollected}, func(event Event) {
+		if (traceRecorder == nil) || (event.Container == nil) {
+			return
+		}
+		if err := traceRecorder.Record(event.Container, []Sample{*event.Sample}, machineCores); err != nil {
+			log.Errorf("Failed to record trace: %s", err)
+		}
+	})
+}
+
+func OpenTraceRecorder(name string) (*TraceRecorder, error) {
+	file, err := os.OpenFile(name, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	recorder := &TraceRecorder{file: file, requests: make(map[string]string), interval: traceFlushInterval}
+	var writer io.Writer = file
+	if strings.HasSuffix(name, ".gz") {
+		recorder.gzipWriter = gzip.NewWriter(file)
+		writer = recorder.gzipWriter
+	}
+	recorder.writer = bufio.NewWriter(writer)
+	recorder.encoder = json.NewEncoder(recorder.writer)
+	return recorder, nil
+}
+
+func (self *TraceRecorder)Record(container *Container, samples []Sample, machineCores int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if len(samples) == 0 {
+		return nil
+	}
+	request := JSONStructureToString(container.CgroupRequest)
+	if self.requests[container.Id] != request {
+		requestInfo := container.CgroupRequest
+		err := self.encoder.Encode(TraceRecord{Time: samples[0].Timestamp.UnixNano(), Id: container.Id, MachineCores: machineCores, Request: &requestInfo})
+		if err != nil {
+			return err
+		}
+		self.requests[container.Id] = request
+	}
+	for _, sample := range samples {
+		err := self.encoder.Encode(TraceRecord{
+			Time: sample.Timestamp.UnixNano(),
+			Id: container.Id,
+			Usage: sample.CPUUsage,
+			Cores: sample.Cores,
+			CPUS: sample.CPUS,
+			Shares: sample.Shares,
+			ThrottledPeriods: sample.ThrottledPeriods,
+			ThrottledTime: sample.ThrottledTime,
+			MachineCores: machineCores,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if self.timer == nil {
+		self.timer = time.AfterFunc(self.interval, self.flushLater)
+	}
+	return nil
+}
+
+// flushLater flushes the records even if no more samples come, e.g. while the loop is paused.
+func (self *TraceRecorder)flushLater() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.timer = nil
+	if self.closed {
+		return
+	}
+	if err := self.flush(); err != nil {
+		log.Errorf("Failed to flush trace: %s", err)
+	}
+}
+
+func (self *TraceRecorder)flush() error {
+	if err := self.writer.Flush(); err != nil {
+		return err
+	}
+	if self.gzipWriter != nil {
+		return self.gzipWriter.Flush()
+	}
+	return nil
+}
+
+func (self *TraceRecorder)Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+	self.closed = true
+	err := self.writer.Flush()
+	if self.gzipWriter != nil {
+		if gzipErr := self.gzipWriter.Close(); err == nil {
+			err = gzipErr
+		}
+	}
+	if fileErr := self.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+func ReadTrace(name string) ([]TraceRecord, error) {
+	var records []TraceRecord
+	var reader io.Reader
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader = file
+	if strings.HasSuffix(name, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	decoder := json.NewDecoder(reader)
+	for {
+		var record TraceRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (self *TraceRecord)GetSample() Sample {
+	return Sample{
+		Timestamp: time.Unix(0, self.Time),
+		CPUUsage: self.Usage,
+		Cores: self.Cores,
+		CoresUsed: self.Usage * float64(self.Cores) / 100,
+		CPUS: self.CPUS,
+		Shares: self.Shares,
+		ThrottledPeriods: self.ThrottledPeriods,
+		ThrottledTime: self.ThrottledTime,
+	}
+}
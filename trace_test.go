@@ -0,0 +1,62 @@
+package cperfc
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTraceRecorderBuffered(t *testing.T) {
+	name := path.Join(t.TempDir(), "trace.json.gz")
+	recorder, err := OpenTraceRecorder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &Container{Id: "test"}
+	for second := 0; second < 3; second++ {
+		if err := recorder.Record(container, []Sample{{Timestamp: at(second), CPUUsage: 50, Cores: 2, CPUS: "0-1"}}, 4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 0 {
+		t.Errorf("expected the samples to be buffered, got %d bytes", info.Size())
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadTrace(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a request record and a record per sample
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(records))
+	}
+	if sample := records[3].GetSample(); !sample.Timestamp.Equal(at(2)) || (sample.CPUS != "0-1") {
+		t.Errorf("expected the last sample at 2s, got %+v", sample)
+	}
+}
+
+func TestTraceRecorderFlushedWithoutSamples(t *testing.T) {
+	name := path.Join(t.TempDir(), "trace.json.gz")
+	recorder, err := OpenTraceRecorder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	recorder.interval = 10 * time.Millisecond
+	if err := recorder.Record(&Container{Id: "test"}, []Sample{{Timestamp: at(0), CPUUsage: 50, Cores: 2}}, 4); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if records, err := ReadTrace(name); (err == nil) && (len(records) == 2) {
+			return
+		}
+	}
+	t.Error("expected the records to be flushed after the interval without more samples")
+}
@@ -0,0 +1,189 @@
+package cperfc
+
+import (
+	"errors"
+	"time"
+
+	cAdvisorInfo "github.com/google/cadvisor/info/v1"
+
+	"cperfc/cgroups"
+)
+
+const maxMaskChanges = 64
+
+// CPUUsage is the CPU usage of a container over a window of cAdvisor stats.
+type CPUUsage struct {
+	Percent			float64			`json:"percent"`			// of the allotted cores
+	Cores			float64			`json:"cores"`				// cores used
+	Allotted		float64			`json:"allotted"`			// cores allotted on average
+	Duration		float64			`json:"duration"`			// second
+	Timestamp		time.Time		`json:"timestamp"`
+	Resets			int				`json:"resets,omitempty"`	// intervals skipped for counter resets
+}
+
+// CPUInterval is the usage between two consecutive stats, against the mask in effect at the time.
+type CPUInterval struct {
+	From			time.Time
+	To				time.Time
+	Usage			uint64			// nanoseconds of CPU time
+	Allotted		float64			// cores allotted on average
+	CPUS			string			// mask at the end
+	end				*cAdvisorInfo.ContainerStats
+}
+
+type maskChange struct {
+	at				time.Time
+	cpus			string
+}
+
+// MaskTimeline keeps when the cpuset of a container changed.
+type MaskTimeline struct {
+	changes			[]maskChange
+}
+
+func init() {
+}
+
+// Set records cpus in effect from at, ignored if it is not a change.
+func (self *MaskTimeline)Set(at time.Time, cpus string) {
+	if last := len(self.changes) - 1; last >= 0 {
+		if self.changes[last].cpus == cpus {
+			return
+		}
+		if at.Before(self.changes[last].at) {
+			at = self.changes[last].at
+		}
+	}
+	self.changes = append(self.changes, maskChange{at: at, cpus: cpus})
+	if len(self.changes) > maxMaskChanges {
+		self.changes = append([]maskChange(nil), self.changes[len(self.changes) - maxMaskChanges:]...)
+	}
+}
+
+// Change records a change from old to cpus at at, seeded with old in effect before if it is empty.
+func (self *MaskTimeline)Change(at time.Time, old string, cpus string) {
+	if (len(self.changes) == 0) && (len(old) > 0) {
+		self.changes = append(self.changes, maskChange{cpus: old})
+	}
+	self.Set(at, cpus)
+}
+
+// Len is the number of masks known.
+func (self *MaskTimeline)Len() int {
+	return len(self.changes)
+}
+
+// At is the mask in effect at t, the earliest known one before the first change.
+func (self *MaskTimeline)At(t time.Time, fallback string) string {
+	if len(self.changes) == 0 {
+		return fallback
+	}
+	cpus := self.changes[0].cpus
+	for _, change := range self.changes {
+		if change.at.After(t) {
+			break
+		}
+		cpus = change.cpus
+	}
+	return cpus
+}
+
+// Allotted is the time weighted average of cores allotted between from and to.
+func (self *MaskTimeline)Allotted(from time.Time, to time.Time, fallback string) float64 {
+	if !to.After(from) {
+		return float64(len(cgroups.DecodeListFormat(self.At(to, fallback))))
+	}
+	cores := 0.0
+	start := from
+	cpus := self.At(from, fallback)
+	for _, change := range self.changes {
+		if !change.at.After(from) {
+			continue
+		}
+		if !change.at.Before(to) {
+			break
+		}
+		cores += float64(len(cgroups.DecodeListFormat(cpus))) * change.at.Sub(start).Seconds()
+		start = change.at
+		cpus = change.cpus
+	}
+	cores += float64(len(cgroups.DecodeListFormat(cpus))) * to.Sub(start).Seconds()
+	return cores / to.Sub(from).Seconds()
+}
+
+// CPUIntervals splits stats into intervals, skipping the ones where the usage counter is reset.
+func CPUIntervals(stats []*cAdvisorInfo.ContainerStats, masks *MaskTimeline, mask string) (intervals []CPUInterval, resets int) {
+	if masks == nil {
+		masks = &MaskTimeline{}
+	}
+	for i := 1; i < len(stats); i++ {
+		prevEvents := stats[i - 1]
+		currEvents := stats[i]
+		if !currEvents.Timestamp.After(prevEvents.Timestamp) {
+			continue
+		}
+		if currEvents.Cpu.Usage.Total < prevEvents.Cpu.Usage.Total {
+			resets++
+			continue
+		}
+		intervals = append(intervals, CPUInterval{
+			From: prevEvents.Timestamp,
+			To: currEvents.Timestamp,
+			Usage: currEvents.Cpu.Usage.Total - prevEvents.Cpu.Usage.Total,
+			Allotted: masks.Allotted(prevEvents.Timestamp, currEvents.Timestamp, mask),
+			CPUS: masks.At(currEvents.Timestamp, mask),
+			end: currEvents,
+		})
+	}
+	return intervals, resets
+}
+
+// Percent is the usage of the interval in percent of the cores allotted.
+func (self CPUInterval)Percent() float64 {
+	seconds := self.To.Sub(self.From).Seconds()
+	if (seconds <= 0) || (self.Allotted <= 0) {
+		return 0
+	}
+	return float64(self.Usage) / 1e9 / (seconds * self.Allotted) * 100
+}
+
+// Cores is the number of cores used on average in the interval.
+func (self CPUInterval)Cores() float64 {
+	seconds := self.To.Sub(self.From).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(self.Usage) / 1e9 / seconds
+}
+
+// CalcCPUUsage sums up the usage of the whole stats, or of the last interval if justNow.
+func CalcCPUUsage(stats []*cAdvisorInfo.ContainerStats, masks *MaskTimeline, mask string, justNow bool) (CPUUsage, error) {
+	var usage CPUUsage
+
+	if justNow && (len(stats) > 2) {
+		stats = stats[len(stats) - 2:]
+	}
+	intervals, resets := CPUIntervals(stats, masks, mask)
+	if len(intervals) == 0 {
+		if resets > 0 {
+			return usage, errors.New("usage counter is reset")
+		}
+		return usage, errors.New("Not enough 'Stats'")
+	}
+	used := 0.0
+	allotted := 0.0
+	for _, interval := range intervals {
+		seconds := interval.To.Sub(interval.From).Seconds()
+		used += float64(interval.Usage) / 1e9
+		allotted += interval.Allotted * seconds
+		usage.Duration += seconds
+	}
+	usage.Timestamp = intervals[len(intervals) - 1].To
+	usage.Resets = resets
+	usage.Cores = used / usage.Duration
+	usage.Allotted = allotted / usage.Duration
+	if allotted > 0 {
+		usage.Percent = used / allotted * 100
+	}
+	return usage, nil
+}
@@ -0,0 +1,157 @@
+package cperfc
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	cAdvisorInfo "github.com/google/cadvisor/info/v1"
+)
+
+var usageBase = time.Unix(1500000000, 0)
+
+type usageStat struct {
+	second			int
+	total			float64			// CPU seconds
+}
+
+type usageChange struct {
+	second			int
+	old				string
+	cpus			string
+}
+
+func at(second int) time.Time {
+	return usageBase.Add(time.Duration(second) * time.Second)
+}
+
+func makeStats(stats []usageStat) []*cAdvisorInfo.ContainerStats {
+	var result []*cAdvisorInfo.ContainerStats
+
+	for _, stat := range stats {
+		containerStats := &cAdvisorInfo.ContainerStats{Timestamp: at(stat.second)}
+		containerStats.Cpu.Usage.Total = uint64(stat.total * 1e9)
+		result = append(result, containerStats)
+	}
+	return result
+}
+
+func makeTimeline(changes []usageChange) *MaskTimeline {
+	masks := &MaskTimeline{}
+	for _, change := range changes {
+		masks.Change(at(change.second), change.old, change.cpus)
+	}
+	return masks
+}
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a - b) < 1e-9
+}
+
+func TestCalcCPUUsage(t *testing.T) {
+	tests := []struct {
+		name			string
+		stats			[]usageStat
+		changes			[]usageChange
+		mask			string			// current mask
+		justNow			bool
+		percent			float64
+		cores			float64
+		allotted		float64
+		resets			int
+		err				bool
+	}{
+		{name: "steady", stats: []usageStat{{0, 0}, {10, 10}, {20, 20}}, mask: "0-1", percent: 50, cores: 1, allotted: 2},
+		{name: "no timeline uses the current mask", stats: []usageStat{{0, 0}, {10, 20}, {20, 40}}, mask: "0-3", percent: 50, cores: 2, allotted: 4},
+		{name: "grown at a stat", stats: []usageStat{{0, 0}, {10, 20}, {20, 40}}, changes: []usageChange{{10, "0-1", "0-3"}}, mask: "0-3", percent: 40 / 60.0 * 100, cores: 2, allotted: 3},
+		{name: "grown, just now", stats: []usageStat{{0, 0}, {10, 20}, {20, 40}}, changes: []usageChange{{10, "0-1", "0-3"}}, mask: "0-3", justNow: true, percent: 50, cores: 2, allotted: 4},
+		{name: "shrunk in an interval", stats: []usageStat{{0, 0}, {10, 10}}, changes: []usageChange{{5, "0-3", "0"}}, mask: "0", percent: 10 / 25.0 * 100, cores: 1, allotted: 2.5},
+		{name: "changed twice", stats: []usageStat{{0, 0}, {10, 10}, {20, 20}, {30, 30}}, changes: []usageChange{{10, "0", "0-1"}, {20, "0-1", "0-3"}}, mask: "0-3", percent: 30 / 70.0 * 100, cores: 1, allotted: 7 / 3.0},
+		{name: "counter reset", stats: []usageStat{{0, 100}, {10, 110}, {20, 5}, {30, 15}}, mask: "0-1", percent: 50, cores: 1, allotted: 2, resets: 1},
+		{name: "counter reset, just now", stats: []usageStat{{0, 100}, {10, 110}, {20, 5}}, mask: "0-1", justNow: true, err: true},
+		{name: "restarted with the same timestamp", stats: []usageStat{{0, 0}, {10, 10}, {10, 0}, {20, 10}}, mask: "0-1", percent: 50, cores: 1, allotted: 2},
+		{name: "not enough stats", stats: []usageStat{{0, 0}}, mask: "0-1", err: true},
+	}
+	for _, test := range tests {
+		usage, err := CalcCPUUsage(makeStats(test.stats), makeTimeline(test.changes), test.mask, test.justNow)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", test.name, usage)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !almostEqual(usage.Percent, test.percent) || !almostEqual(usage.Cores, test.cores) || !almostEqual(usage.Allotted, test.allotted) || (usage.Resets != test.resets) {
+			t.Errorf("%s: expected %.4f%% of %.4f cores, %.4f cores used, %d resets, got %+v", test.name, test.percent, test.allotted, test.cores, test.resets, usage)
+		}
+	}
+}
+
+func TestMaskTimeline(t *testing.T) {
+	tests := []struct {
+		name			string
+		changes			[]usageChange
+		second			int
+		fallback		string
+		expected		string
+	}{
+		{name: "empty", second: 0, fallback: "0-3", expected: "0-3"},
+		{name: "seeded before the first change", changes: []usageChange{{10, "0-1", "0-3"}}, second: 5, expected: "0-1"},
+		{name: "at the change", changes: []usageChange{{10, "0-1", "0-3"}}, second: 10, expected: "0-3"},
+		{name: "not a change", changes: []usageChange{{10, "0-1", "0-3"}, {20, "0-3", "0-3"}}, second: 15, expected: "0-3"},
+		{name: "out of order", changes: []usageChange{{10, "0-1", "0-3"}, {5, "0-3", "0"}}, second: 9, expected: "0-1"},
+	}
+	for _, test := range tests {
+		if cpus := makeTimeline(test.changes).At(at(test.second), test.fallback); cpus != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, cpus)
+		}
+	}
+}
+
+func TestObserveContainer(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time { return at(30) }
+
+	container := &Container{Id: "test"}
+	info := &cAdvisorInfo.ContainerInfo{}
+	info.Spec.CreationTime = usageBase
+	info.Spec.Cpu.Mask = "0-1"
+	info.Stats = makeStats([]usageStat{{0, 0}, {10, 20}})
+	observeContainer(container, info)
+	mergeStats(container, info.Stats)
+	// a scaling action after the first sampling must not change the mask of the stats before
+	container.masks.Change(at(10), "0-1", "0-3")
+	info.Spec.Cpu.Mask = "0-3"
+	info.Stats = makeStats([]usageStat{{20, 60}})
+	observeContainer(container, info)
+	usage, err := CalcCPUUsage(mergeStats(container, info.Stats), &container.masks, info.Spec.Cpu.Mask, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !almostEqual(usage.Allotted, 3) || !almostEqual(usage.Percent, 60 / 60.0 * 100) {
+		t.Errorf("scaled: expected 100%% of 3 cores, got %+v", usage)
+	}
+
+	// changed out of cperfc, the stats fetched before it keep the old mask
+	info.Spec.Cpu.Mask = "0"
+	info.Stats = makeStats([]usageStat{{25, 70}, {30, 75}})
+	observeContainer(container, info)
+	if cpus := container.masks.At(at(25), ""); cpus != "0-3" {
+		t.Errorf("changed out of cperfc: expected 0-3 at 25s, got %s", cpus)
+	}
+	if cpus := container.masks.At(at(30), ""); cpus != "0" {
+		t.Errorf("changed out of cperfc: expected 0 at 30s, got %s", cpus)
+	}
+	mergeStats(container, info.Stats)
+
+	// restarted, the stats of the previous container are forgotten
+	info.Spec.CreationTime = at(28)
+	info.Stats = makeStats([]usageStat{{35, 1}})
+	observeContainer(container, info)
+	if stats := mergeStats(container, info.Stats); len(stats) != 1 {
+		t.Errorf("restarted: expected only the new stats, got %d", len(stats))
+	}
+}
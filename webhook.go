@@ -0,0 +1,272 @@
+package cperfc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cperfc/config"
+	"cperfc/log"
+)
+
+const (
+	WebhookScaled = "scaled"
+	WebhookMaxCores = "max_cores"
+	WebhookPoolExhausted = "pool_exhausted"
+	WebhookDisappeared = "disappeared"
+	WebhookCAdvisorLost = "cadvisor_lost"
+)
+
+const webhookQueueSize = 256
+const webhookMaxBackoff = 60 * time.Second
+const WebhookSignatureHeader = "X-Cperfc-Signature"
+
+type WebhookEvent struct {
+	Id				string			`json:"id"`
+	Type			string			`json:"type"`
+	Timestamp		time.Time		`json:"timestamp"`
+	Container		string			`json:"container,omitempty"`
+	Message			string			`json:"message"`
+	Action			*ScalingAction	`json:"action,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL				string			`json:"url"`
+	Secret			string			`json:"secret,omitempty"`
+	Events			[]string		`json:"events,omitempty"`		// empty for all events
+	Containers		[]string		`json:"containers,omitempty"`	// empty for all containers
+}
+
+type Webhook struct {
+	Config			WebhookConfig
+	client			*http.Client
+	retries			int
+	queue			chan WebhookEvent
+	events			map[string]bool
+	containers		map[string]bool
+	Delivered		int64
+	Failed			int64
+	Dropped			int64
+}
+
+type WebhookManager struct {
+	lock			sync.RWMutex
+	hooks			[]*Webhook
+	sequence		uint64
+}
+
+var webhookManager WebhookManager
+var webhookSleep = time.Sleep
+
+func init() {
+}
+
+func GetWebhookManager() *WebhookManager {
+	return &webhookManager
+}
+
+func subscribeWebhooks() {
+	types := []string{EventDecisionMade, EventCeilingReached, EventContainerRemoved, EventCAdvisorState}
+	GetEventBus().Subscribe("webhooks", types, func(event Event) {
+		manager := GetWebhookManager()
+		switch event.Type {
+		case EventDecisionMade:
+			if (len(event.Action.Writes) > 0) && !event.Action.DryRun {
+				manager.Notify(WebhookScaled, event.Id, event.Action, "[%s] %s", event.Action.Policy, event.Action.Reason)
+			}
+		case EventCeilingReached:
+			manager.Notify(event.Reason, event.Id, nil, "%s", event.Message)
+		case EventContainerRemoved:
+			if event.Reason == "disappeared" {
+				manager.Notify(WebhookDisappeared, event.Id, nil, "The container '%s' is registered, but disappeared... clean up", event.Id)
+			}
+		case EventCAdvisorState:
+			if event.Reason == CircuitOpen {
+				manager.Notify(WebhookCAdvisorLost, "", nil, "%s: %s", event.Message, event.Error)
+			}
+		}
+	})
+}
+
+func StartWebhooks() error {
+	if len(config.WebhookFile) == 0 {
+		GetWebhookManager().SetWebhooks(nil)
+		return nil
+	}
+	hooks, err := LoadWebhookConfig(config.WebhookFile)
+	if err != nil {
+		return fmt.Errorf("failed to load webhooks[%s]: %s", config.WebhookFile, err)
+	}
+	GetWebhookManager().SetWebhooks(hooks)
+	log.Infof("%d webhooks are loaded from %s.", len(hooks), config.WebhookFile)
+	return nil
+}
+
+// StopWebhooks lets the webhooks finish the events in their queues and stop.
+func StopWebhooks() {
+	GetWebhookManager().SetWebhooks(nil)
+}
+
+func LoadWebhookConfig(name string) ([]WebhookConfig, error) {
+	var hooks []WebhookConfig
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&hooks); err != nil {
+		return nil, err
+	}
+	for _, hook := range hooks {
+		if len(hook.URL) == 0 {
+			return nil, fmt.Errorf("webhook without 'url'")
+		}
+	}
+	return hooks, nil
+}
+
+func (self *WebhookManager)SetWebhooks(configs []WebhookConfig) {
+	var hooks []*Webhook
+
+	for _, hookConfig := range configs {
+		hook := &Webhook{
+			Config: hookConfig,
+			client: &http.Client{Timeout: time.Duration(config.WebhookTimeout) * time.Second},
+			retries: config.WebhookRetries,
+			queue: make(chan WebhookEvent, webhookQueueSize),
+			events: make(map[string]bool),
+			containers: make(map[string]bool),
+		}
+		for _, event := range hookConfig.Events {
+			hook.events[event] = true
+		}
+		for _, container := range hookConfig.Containers {
+			hook.containers[container] = true
+		}
+		go hook.deliver()
+		hooks = append(hooks, hook)
+	}
+	self.lock.Lock()
+	old := self.hooks
+	self.hooks = hooks
+	self.lock.Unlock()
+	for _, hook := range old {
+		close(hook.queue)
+	}
+}
+
+func (self *WebhookManager)GetWebhooks() []*Webhook {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.hooks
+}
+
+func (self *WebhookManager)Notify(eventType string, container string, action *ScalingAction, format string, args ...interface{}) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if len(self.hooks) == 0 {
+		return
+	}
+	event := WebhookEvent{
+		Id: fmt.Sprintf("%d-%d", time.Now().Unix(), atomic.AddUint64(&self.sequence, 1)),
+		Type: eventType,
+		Timestamp: clock(),
+		Container: container,
+		Message: fmt.Sprintf(format, args...),
+		Action: action,
+	}
+	for _, hook := range self.hooks {
+		if !hook.accept(event) {
+			continue
+		}
+		select {
+		case hook.queue <- event:
+		default:
+			atomic.AddInt64(&hook.Dropped, 1)
+			log.Warnf("Webhook %s: queue is full, %s event is dropped", hook.Config.URL, event.Type)
+		}
+	}
+}
+
+func (self *Webhook)accept(event WebhookEvent) bool {
+	if (len(self.events) > 0) && !self.events[event.Type] {
+		return false
+	}
+	if (len(self.containers) > 0) && (len(event.Container) > 0) && !self.containers[event.Container] {
+		return false
+	}
+	return true
+}
+
+func (self *Webhook)deliver() {
+	for event := range self.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			retry, err := self.post(event, body)
+			if err == nil {
+				atomic.AddInt64(&self.Delivered, 1)
+				break
+			}
+			if !retry || (attempt >= self.retries) {
+				atomic.AddInt64(&self.Failed, 1)
+				log.Errorf("Webhook %s: gave up %s event %s after %d attempts: %s", self.Config.URL, event.Type, event.Id, attempt + 1, err)
+				break
+			}
+			log.Warnf("Webhook %s: %s, retry in %s", self.Config.URL, err, backoff)
+			webhookSleep(backoff)
+			backoff *= 2
+			if backoff > webhookMaxBackoff {
+				backoff = webhookMaxBackoff
+			}
+		}
+	}
+}
+
+func (self *Webhook)post(event WebhookEvent, body []byte) (retry bool, err error) {
+	request, err := http.NewRequest("POST", self.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	request.Header.Set("X-Cperfc-Event", event.Type)
+	request.Header.Set("X-Cperfc-Delivery", event.Id)
+	if len(self.Config.Secret) > 0 {
+		request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(self.Config.Secret, body))
+	}
+	response, err := self.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if (response.StatusCode >= 200) && (response.StatusCode < 300) {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", response.Status)
+	return (response.StatusCode >= 500) || (response.StatusCode == http.StatusTooManyRequests), err
+}
+
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyWebhookSignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, body)), []byte(signature))
+}
@@ -0,0 +1,208 @@
+package cperfc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cperfc/config"
+)
+
+// setupWebhookTest serves the webhooks by handler and returns the requests and their bodies.
+func setupWebhookTest(t *testing.T, retries int, handler func(w http.ResponseWriter, attempt int), hooks ...WebhookConfig) (func() []*http.Request, func() [][]byte) {
+	var lock sync.Mutex
+	var requests []*http.Request
+	var bodies [][]byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		attempt := len(requests)
+		lock.Unlock()
+		handler(w, attempt)
+	}))
+	savedRetries := config.WebhookRetries
+	t.Cleanup(func() {
+		GetWebhookManager().SetWebhooks(nil)
+		server.Close()
+		config.WebhookRetries = savedRetries
+	})
+	config.WebhookRetries = retries
+	for i := range hooks {
+		hooks[i].URL = server.URL
+	}
+	GetWebhookManager().SetWebhooks(hooks)
+	getRequests := func() []*http.Request {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*http.Request(nil), requests...)
+	}
+	getBodies := func() [][]byte {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([][]byte(nil), bodies...)
+	}
+	return getRequests, getBodies
+}
+
+// waitWebhook waits until the webhook has delivered or given up 'count' events.
+func waitWebhook(t *testing.T, hook *Webhook, count int64) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if atomic.LoadInt64(&hook.Delivered) + atomic.LoadInt64(&hook.Failed) >= count {
+			return
+		}
+	}
+	t.Fatalf("expected %d events to be done, delivered %d, failed %d", count, atomic.LoadInt64(&hook.Delivered), atomic.LoadInt64(&hook.Failed))
+}
+
+func TestWebhookSignature(t *testing.T) {
+	requests, bodies := setupWebhookTest(t, 0, func(w http.ResponseWriter, attempt int) {}, WebhookConfig{Secret: "secret"})
+	GetWebhookManager().Notify(WebhookScaled, "test", nil, "scaled")
+	waitWebhook(t, GetWebhookManager().GetWebhooks()[0], 1)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(bodies()[0])
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	request := requests()[0]
+	if signature := request.Header.Get(WebhookSignatureHeader); signature != expected {
+		t.Errorf("expected %s, got %s", expected, signature)
+	}
+	if !VerifyWebhookSignature("secret", bodies()[0], request.Header.Get(WebhookSignatureHeader)) {
+		t.Error("expected the signature to be verified")
+	}
+	if VerifyWebhookSignature("other", bodies()[0], request.Header.Get(WebhookSignatureHeader)) {
+		t.Error("expected the signature not to be verified with another secret")
+	}
+	if event := request.Header.Get("X-Cperfc-Event"); event != WebhookScaled {
+		t.Errorf("expected the event %s, got %s", WebhookScaled, event)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	requests, _ := setupWebhookTest(t, 0, func(w http.ResponseWriter, attempt int) {}, WebhookConfig{})
+	GetWebhookManager().Notify(WebhookScaled, "test", nil, "scaled")
+	waitWebhook(t, GetWebhookManager().GetWebhooks()[0], 1)
+	if signature := requests()[0].Header.Get(WebhookSignatureHeader); len(signature) > 0 {
+		t.Errorf("expected no signature without a secret, got %s", signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var lock sync.Mutex
+	var sleeps []time.Duration
+
+	defer func(sleep func(time.Duration)) { webhookSleep = sleep }(webhookSleep)
+	webhookSleep = func(backoff time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		sleeps = append(sleeps, backoff)
+	}
+	tests := []struct {
+		name		string
+		status		int
+		retries		int
+		attempts	int
+		delivered	bool
+	}{
+		{"5xx", http.StatusServiceUnavailable, 7, 8, false},
+		{"429", http.StatusTooManyRequests, 2, 3, false},
+		{"4xx", http.StatusBadRequest, 7, 1, false},
+		{"no retries", http.StatusInternalServerError, 0, 1, false},
+		{"recovered", http.StatusInternalServerError, 7, 3, true},
+	}
+	for _, test := range tests {
+		lock.Lock()
+		sleeps = nil
+		lock.Unlock()
+		status, delivered := test.status, test.delivered
+		requests, _ := setupWebhookTest(t, test.retries, func(w http.ResponseWriter, attempt int) {
+			if delivered && (attempt == 3) {
+				return
+			}
+			w.WriteHeader(status)
+		}, WebhookConfig{})
+		hook := GetWebhookManager().GetWebhooks()[0]
+		GetWebhookManager().Notify(WebhookScaled, "test", nil, "scaled")
+		waitWebhook(t, hook, 1)
+		if attempts := len(requests()); attempts != test.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", test.name, test.attempts, attempts)
+		}
+		if (atomic.LoadInt64(&hook.Delivered) == 1) != test.delivered {
+			t.Errorf("%s: expected delivered %v, got %d delivered and %d failed", test.name, test.delivered, hook.Delivered, hook.Failed)
+		}
+		lock.Lock()
+		if len(sleeps) != test.attempts - 1 {
+			t.Errorf("%s: expected %d backoffs, got %v", test.name, test.attempts - 1, sleeps)
+		}
+		lock.Unlock()
+		GetWebhookManager().SetWebhooks(nil)
+	}
+
+	// the backoff doubles from a second up to the cap
+	lock.Lock()
+	sleeps = nil
+	lock.Unlock()
+	setupWebhookTest(t, 9, func(w http.ResponseWriter, attempt int) {
+		w.WriteHeader(http.StatusBadGateway)
+	}, WebhookConfig{})
+	GetWebhookManager().Notify(WebhookScaled, "test", nil, "scaled")
+	waitWebhook(t, GetWebhookManager().GetWebhooks()[0], 1)
+	expected := []time.Duration{1, 2, 4, 8, 16, 32, 60, 60, 60}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(sleeps) != len(expected) {
+		t.Fatalf("expected %d backoffs, got %v", len(expected), sleeps)
+	}
+	for i := range expected {
+		if sleeps[i] != expected[i] * time.Second {
+			t.Errorf("expected the backoff %d to be %s, got %v", i, expected[i] * time.Second, sleeps)
+			break
+		}
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	hook := WebhookConfig{Events: []string{WebhookScaled, WebhookCAdvisorLost}, Containers: []string{"a"}}
+	requests, _ := setupWebhookTest(t, 0, func(w http.ResponseWriter, attempt int) {}, hook, WebhookConfig{})
+	tests := []struct {
+		event		string
+		container	string
+		accepted	bool
+	}{
+		{WebhookScaled, "a", true},
+		{WebhookScaled, "b", false},
+		{WebhookMaxCores, "a", false},
+		{WebhookCAdvisorLost, "", true},
+	}
+	filtered, all := GetWebhookManager().GetWebhooks()[0], GetWebhookManager().GetWebhooks()[1]
+	accepted := int64(0)
+	for _, test := range tests {
+		if got := filtered.accept(WebhookEvent{Type: test.event, Container: test.container}); got != test.accepted {
+			t.Errorf("%s of '%s': expected accepted %v, got %v", test.event, test.container, test.accepted, got)
+		}
+		if !all.accept(WebhookEvent{Type: test.event, Container: test.container}) {
+			t.Errorf("%s of '%s': expected a webhook without filters to accept it", test.event, test.container)
+		}
+		GetWebhookManager().Notify(test.event, test.container, nil, "%s", test.event)
+		if test.accepted {
+			accepted++
+		}
+	}
+	waitWebhook(t, filtered, accepted)
+	waitWebhook(t, all, int64(len(tests)))
+	if count := len(requests()); count != int(accepted) + len(tests) {
+		t.Errorf("expected %d deliveries, got %d", int(accepted) + len(tests), count)
+	}
+	if (filtered.Delivered != accepted) || (all.Delivered != int64(len(tests))) {
+		t.Errorf("expected %d and %d delivered, got %d and %d", accepted, len(tests), filtered.Delivered, all.Delivered)
+	}
+}